@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+	"github.com/spf13/cobra"
+)
+
+var (
+	clientTarget          string
+	clientResponseTimeout time.Duration
+)
+
+// clientCmd represents the client command.
+var clientCmd = &cobra.Command{
+	Use:   "client [request]",
+	Short: "send requests to a server and print the responses",
+	Long: `Send requests to a server, e.g. a running multiplexer, and print the decoded
+responses with their latency. A request given as arguments is sent once;
+otherwise requests are read from stdin, one per line.
+
+Requests are written in hex, except for the text protocols echo and scpi,
+whose lines are sent as they are, and http, whose requests are written as
+METHOD PATH. Modbus requests can also be written as
+  read-coils|read-discrete|read-holding|read-input UNIT ADDRESS QUANTITY
+  write-coil UNIT ADDRESS on|off
+  write-register UNIT ADDRESS VALUE
+  write-coils UNIT ADDRESS on|off...
+  write-registers UNIT ADDRESS VALUE...
+where decimal addresses of 5 or 6 digits starting with the digit of their
+table (0 coils, 1 discrete inputs, 3 input registers, 4 holding registers),
+e.g. 40001 or 400001 for the first holding register, are taken as Modicon
+addresses and converted to zero-based ones. Other addresses, e.g. 100 or
+0x9C41, are zero-based. Modbus transaction IDs are filled in.`,
+	Example: `  tcp-multiplexer client -p modbus -t 127.0.0.1:8000 read-holding 1 40001 10
+  printf 'GET /\nGET /health\n' | tcp-multiplexer client -p http -t 127.0.0.1:8000`,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reader, ok := message.Readers[applicationProtocol]
+		if !ok {
+			return fmt.Errorf("application protocol %q is not supported", applicationProtocol)
+		}
+
+		cmd.SilenceUsage = true
+
+		conn, err := net.DialTimeout("tcp", clientTarget, clientResponseTimeout)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = conn.Close() }()
+
+		c := &client{conn: conn, buf: bufio.NewReader(conn), reader: reader, out: cmd.OutOrStdout()}
+		if len(args) > 0 {
+			return c.send(strings.Join(args, " "))
+		}
+
+		interactive := false
+		if fi, err := os.Stdin.Stat(); err == nil {
+			interactive = fi.Mode()&os.ModeCharDevice != 0
+		}
+		lines := bufio.NewScanner(os.Stdin)
+		for {
+			if interactive {
+				_, _ = fmt.Fprint(c.out, "> ")
+			}
+			if !lines.Scan() {
+				return lines.Err()
+			}
+			line := strings.TrimSpace(lines.Text())
+			if line == "" {
+				continue
+			}
+			err := c.send(line)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				_, _ = fmt.Fprintln(cmd.ErrOrStderr(), err)
+				continue
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return errors.New("connection closed by server")
+			}
+			if err != nil {
+				_, _ = fmt.Fprintln(cmd.ErrOrStderr(), err)
+				if !interactive {
+					return err
+				}
+			}
+		}
+	},
+}
+
+// client sends requests over a single connection.
+type client struct {
+	conn          net.Conn
+	buf           *bufio.Reader
+	reader        message.Reader
+	out           io.Writer
+	transactionID uint16
+}
+
+// send sends the request written in line and prints its responses.
+func (c *client) send(line string) error {
+	req, err := c.request(line)
+	if err != nil {
+		return err
+	}
+	describe(c.out, c.reader, "request", req, true)
+
+	sent := time.Now()
+	if _, err := c.conn.Write(req); err != nil {
+		return err
+	}
+
+	expected := message.ExpectedResponses(c.reader, req)
+	if expected == message.NoResponse {
+		_, _ = fmt.Fprintln(c.out, "no response expected")
+		return nil
+	}
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(clientResponseTimeout)); err != nil {
+			return err
+		}
+		resp, err := message.ReadResponse(c.reader, c.buf)
+		if err != nil {
+			return err
+		}
+		describe(c.out, c.reader, fmt.Sprintf("response after %s", time.Since(sent).Round(time.Microsecond)), resp, false)
+
+		if expected != message.UntilFinal {
+			return nil
+		}
+		if e, ok := c.reader.(message.Exchanger); !ok || e.IsFinal(req, resp) {
+			return nil
+		}
+	}
+}
+
+// request frames the request written in line for the protocol of c.
+func (c *client) request(line string) ([]byte, error) {
+	switch c.reader.Name() {
+	case (message.EchoMessageReader{}).Name(), (message.SCPIMessageReader{}).Name():
+		return []byte(line + "\n"), nil
+	case (message.HTTPMessageReader{}).Name():
+		method, path, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, errors.New("usage: METHOD PATH")
+		}
+		return fmt.Appendf(nil, "%s %s HTTP/1.1\r\nHost: %s\r\n\r\n", method, strings.TrimSpace(path), clientTarget), nil
+	}
+
+	req, hexErr := parseHex(line)
+	if hexErr == nil {
+		return req, nil
+	}
+	if !message.IsModbus(c.reader) {
+		return nil, hexErr
+	}
+	m, err := message.ParseModbusRequest(line)
+	if err != nil {
+		return nil, err
+	}
+	c.transactionID++
+	m.TransactionID = c.transactionID
+	return message.EncodeModbus(c.reader, m)
+}
+
+// describe prints a frame with its decoded fields.
+func describe(w io.Writer, reader message.Reader, title string, frame []byte, request bool) {
+	_, _ = fmt.Fprintf(w, "%s, %d bytes: %x\n", title, len(frame), frame)
+	d, ok := reader.(message.Describer)
+	if !ok {
+		return
+	}
+	fields, err := d.Describe(frame, request)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "  %v\n", err)
+		return
+	}
+	printFields(w, fields)
+}
+
+func init() {
+	rootCmd.AddCommand(clientCmd)
+
+	clientCmd.Flags().StringVarP(&applicationProtocol, "applicationProtocol", "p", "echo", "protocol spoken by the server (see list)")
+	clientCmd.Flags().StringVarP(&clientTarget, "targetServer", "t", "127.0.0.1:8000", "server to send requests to (host:port)")
+	clientCmd.Flags().DurationVar(&clientResponseTimeout, "responseTimeout", 5*time.Second, "timeout for connecting and for each response")
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+	"github.com/spf13/cobra"
+)
+
+var (
+	decodeFile      string
+	decodeBinary    bool
+	decodeDirection string
+	decodeUnmasked  bool
+)
+
+// decodeCmd represents the decode command.
+var decodeCmd = &cobra.Command{
+	Use:   "decode [hex...]",
+	Short: "split a capture into frames and decode them",
+	Long: `Split a hex or binary capture into frames with the framing of an application
+protocol, and print the fields of each frame. The capture is given as hex
+arguments, or read from --file or stdin. Sensitive data, e.g. card numbers of
+ISO 8583 messages, is masked as in the logs unless --unmasked is given.`,
+	Example: `  tcp-multiplexer decode -p modbus-serial 0103000a0002e409
+  tcp-multiplexer decode -p modbus --binary -f capture.bin`,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reader, ok := message.Readers[applicationProtocol]
+		if !ok {
+			return fmt.Errorf("application protocol %q is not supported", applicationProtocol)
+		}
+		if iso8583Spec != "" {
+			var err error
+			reader, err = iso8583Reader(reader)
+			if err != nil {
+				return fmt.Errorf("invalid ISO 8583 configuration: %w", err)
+			}
+		}
+		next, err := frameDirection(decodeDirection)
+		if err != nil {
+			return err
+		}
+
+		data, err := decodeInput(args)
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+		return decodeFrames(cmd.OutOrStdout(), reader, data, next, decodeUnmasked)
+	},
+}
+
+// frameDirection returns a function that tells whether the frame with the
+// given index is a request.
+func frameDirection(direction string) (func(i int) bool, error) {
+	switch direction {
+	case "alternate":
+		return func(i int) bool { return i%2 == 0 }, nil
+	case "request":
+		return func(int) bool { return true }, nil
+	case "response":
+		return func(int) bool { return false }, nil
+	}
+	return nil, fmt.Errorf("invalid direction %q", direction)
+}
+
+// decodeInput returns the capture given as hex arguments, or read from the
+// file or stdin as hex or binary.
+func decodeInput(args []string) ([]byte, error) {
+	if len(args) > 0 {
+		if decodeFile != "" {
+			return nil, errors.New("hex arguments cannot be combined with --file")
+		}
+		return parseHex(strings.Join(args, ""))
+	}
+
+	var data []byte
+	var err error
+	if decodeFile == "" || decodeFile == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(decodeFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if decodeBinary {
+		return data, nil
+	}
+	return parseHex(string(data))
+}
+
+// parseHex decodes hex digits, ignoring white space and a leading 0x.
+func parseHex(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %w", err)
+	}
+	return data, nil
+}
+
+// decodeFrames splits data into frames with the framing of reader and prints
+// each frame with its decoded fields. Frames are masked by readers that
+// implement message.Masker unless unmasked is set. It stops at the first frame
+// that cannot be read or decoded, and returns an error telling where it
+// starts.
+func decodeFrames(w io.Writer, reader message.Reader, data []byte, isRequest func(i int) bool, unmasked bool) error {
+	dump := func(frame []byte) []byte {
+		if m, ok := reader.(message.Masker); ok && !unmasked {
+			return m.Mask(frame)
+		}
+		return frame
+	}
+
+	src := bytes.NewReader(data)
+	// text protocols read through a bufio.Reader, which reuses this one
+	// instead of consuming bytes beyond the end of the frame
+	buf := bufio.NewReader(src)
+	offset := func() int {
+		return len(data) - src.Len() - buf.Buffered()
+	}
+
+	for i := 0; ; i++ {
+		start := offset()
+		if start == len(data) {
+			_, _ = fmt.Fprintf(w, "%d frames, %d bytes\n", i, len(data))
+			return nil
+		}
+
+		request := isRequest(i)
+		var frame []byte
+		var err error
+		if request {
+			frame, err = message.ReadRequest(reader, buf)
+		} else {
+			frame, err = message.ReadResponse(reader, buf)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("frame %d at offset %d: truncated, only %d bytes left: %x", i+1, start, len(data)-start, dump(data[start:]))
+			}
+			return fmt.Errorf("frame %d at offset %d: %w", i+1, start, err)
+		}
+
+		kind := "response"
+		if request {
+			kind = "request"
+		}
+		_, _ = fmt.Fprintf(w, "frame %d at offset %d, %s, %d bytes: %x\n", i+1, start, kind, len(frame), dump(frame))
+
+		d, ok := reader.(message.Describer)
+		if !ok {
+			continue
+		}
+		fields, err := d.Describe(frame, request)
+		if err != nil {
+			return fmt.Errorf("frame %d at offset %d: %w", i+1, start, err)
+		}
+		printFields(w, fields)
+	}
+}
+
+// printFields prints decoded fields one per line, indented below their frame.
+func printFields(w io.Writer, fields []message.Field) {
+	width := 0
+	for _, f := range fields {
+		width = max(width, len(f.Name))
+	}
+	for _, f := range fields {
+		_, _ = fmt.Fprintf(w, "  %-*s  %v\n", width+1, f.Name+":", f.Value)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(decodeCmd)
+
+	decodeCmd.Flags().StringVarP(&applicationProtocol, "applicationProtocol", "p", "echo", "protocol whose framing splits the capture (see list)")
+	decodeCmd.Flags().StringVarP(&decodeFile, "file", "f", "", "file with the capture, - for stdin (default stdin unless hex arguments are given)")
+	decodeCmd.Flags().BoolVar(&decodeBinary, "binary", false, "the file or stdin holds raw bytes instead of hex")
+	decodeCmd.Flags().StringVar(&decodeDirection, "direction", "alternate", "which frames are requests: alternate (starting with a request), request or response")
+	decodeCmd.Flags().BoolVar(&decodeUnmasked, "unmasked", false, "print frames including the sensitive data the protocol masks in logs")
+	decodeCmd.Flags().StringVar(&iso8583Spec, "iso8583Spec", "", "JSON file with the ISO 8583 message layout and field specifications (iso8583/mpu only)")
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+)
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []byte
+		wantErr bool
+	}{
+		{"plain", "0103000a", []byte{0x01, 0x03, 0x00, 0x0a}, false},
+		{"white space", " 01 03\n00\t0A\n", []byte{0x01, 0x03, 0x00, 0x0a}, false},
+		{"0x prefix", "0x0103", []byte{0x01, 0x03}, false},
+		{"empty", "", []byte{}, false},
+		{"odd length", "010", nil, true},
+		{"not hex", "01zz", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHex(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, tt.want) {
+				t.Errorf("parseHex() got = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeFrames(t *testing.T) {
+	alternate, err := frameDirection("alternate")
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	request, err := frameDirection("request")
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+
+	const pan = "4111111111111111"
+	iso, err := message.ISO8583MessageReader{}.Encode(&message.ISO8583Message{
+		MTI:    "0200",
+		Fields: map[int]string{2: pan, 11: "000001"},
+	})
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+
+	tests := []struct {
+		name      string
+		reader    message.Reader
+		capture   string
+		isRequest func(i int) bool
+		unmasked  bool
+		want      []string
+		notWant   string
+		wantErr   string
+	}{
+		{
+			name:      "request and response",
+			reader:    message.ModbusSerialMessageReader{},
+			capture:   "0103000a0002e409010304000100022a32",
+			isRequest: alternate,
+			want: []string{
+				"frame 1 at offset 0, request, 8 bytes: 0103000a0002e409\n",
+				"  address:   10\n",
+				"frame 2 at offset 8, response, 9 bytes: 010304000100022a32\n",
+				"  values:    [1 2]\n",
+				"2 frames, 17 bytes\n",
+			},
+		},
+		{
+			name:      "truncated",
+			reader:    message.ModbusSerialMessageReader{},
+			capture:   "0103000a0002e4090103040001",
+			isRequest: alternate,
+			want:      []string{"frame 1 at offset 0, request"},
+			wantErr:   "frame 2 at offset 8: truncated, only 5 bytes left: 0103040001",
+		},
+		{
+			name:      "bad CRC",
+			reader:    message.ModbusSerialMessageReader{},
+			capture:   "0103000a0002e409010304000100022a33",
+			isRequest: alternate,
+			want:      []string{"frame 1 at offset 0, request"},
+			wantErr:   "frame 2 at offset 8: protocol error: CRC mismatch",
+		},
+		{
+			name:      "card data masked",
+			reader:    message.ISO8583MessageReader{},
+			capture:   hex.EncodeToString(iso),
+			isRequest: request,
+			want:      []string{hex.EncodeToString([]byte("411111******1111")), "1 frames"},
+			notWant:   hex.EncodeToString([]byte(pan)),
+		},
+		{
+			name:      "card data unmasked",
+			reader:    message.ISO8583MessageReader{},
+			capture:   hex.EncodeToString(iso),
+			isRequest: request,
+			unmasked:  true,
+			want:      []string{hex.EncodeToString(iso), "1 frames"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := parseHex(tt.capture)
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			var out strings.Builder
+			err = decodeFrames(&out, tt.reader, data, tt.isRequest, tt.unmasked)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("Expected error %q, but got: %v", tt.wantErr, err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+				}
+			}
+			if tt.notWant != "" && strings.Contains(out.String(), tt.notWant) {
+				t.Errorf("Expected output not to contain %q, got:\n%s", tt.notWant, out.String())
+			}
+		})
+	}
+}
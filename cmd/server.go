@@ -23,14 +23,19 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
 	"github.com/ingmarstein/tcp-multiplexer/pkg/multiplexer"
+	"github.com/ingmarstein/tcp-multiplexer/pkg/serial"
 	"github.com/spf13/cobra"
 )
 
@@ -38,9 +43,54 @@ var (
 	port                string
 	targetServer        string
 	applicationProtocol string
+	targetProtocol      string
 	timeout             int
+	connectTimeout      time.Duration
+	responseTimeout     time.Duration
+	idleTimeout         time.Duration
+	requestTimeout      time.Duration
 	delay               time.Duration
 	retryDelay          time.Duration
+	maxRetryDelay       time.Duration
+	queueTimeout        time.Duration
+	failureThreshold    int
+	baudRate            int
+	dataBits            int
+	parity              string
+	stopBits            int
+	framing             string
+	targetFraming       string
+	frameSilence        time.Duration
+	turnaroundDelay     time.Duration
+	requestGap          time.Duration
+	unitGap             map[string]string
+	maxRate             float64
+	extraPorts          []string
+	classes             []string
+	scheduling          string
+	clientQueue         int
+	clientRate          float64
+	clientByteRate      float64
+	ipRate              float64
+	ipByteRate          float64
+	rateLimitAction     string
+	maxClients          int
+	maxClientsPerIP     int
+	allow               []string
+	deny                []string
+	leaseRequests       int
+	leaseTimeout        time.Duration
+	rawGap              time.Duration
+	unsolicited         string
+	iso8583Spec         string
+	iso8583SignOn       string
+	iso8583EchoTest     string
+	iso8583EchoInterval time.Duration
+	iso8583Fields       map[string]string
+	iso8583HoldMTIs     []string
+	iso8583HoldField    int
+	logMasks            []string
+	statusListen        string
 )
 
 // serverCmd represents the server command.
@@ -60,7 +110,8 @@ var serverCmd = &cobra.Command{
 			"version", version,
 			"port", port,
 			"targetServer", targetServer,
-			"applicationProtocol", applicationProtocol)
+			"applicationProtocol", applicationProtocol,
+			"targetProtocol", targetProtocol)
 
 		msgReader, ok := message.Readers[applicationProtocol]
 		if !ok {
@@ -68,7 +119,150 @@ var serverCmd = &cobra.Command{
 			os.Exit(2)
 		}
 
-		mux := multiplexer.New(targetServer, port, msgReader, delay, time.Duration(timeout)*time.Second, retryDelay)
+		if applicationProtocol == (message.RawMessageReader{}).Name() {
+			msgReader = message.RawMessageReader{Gap: rawGap}
+		}
+
+		networkManagement := iso8583SignOn != "" || iso8583EchoInterval > 0
+		session := len(iso8583HoldMTIs) > 0 || iso8583HoldField != 0
+		if iso8583Spec != "" || networkManagement || session {
+			var err error
+			msgReader, err = iso8583Reader(msgReader)
+			if err != nil {
+				slog.Error("invalid ISO 8583 configuration", "error", err)
+				os.Exit(2)
+			}
+		}
+
+		targetReader := msgReader
+		if targetProtocol != "" {
+			targetReader, ok = message.Readers[targetProtocol]
+			if !ok {
+				slog.Error("target protocol is not supported", "protocol", targetProtocol)
+				os.Exit(2)
+			}
+		}
+
+		msgReader, err := framedReader(msgReader, framing)
+		if err != nil {
+			slog.Error("invalid client framing", "error", err)
+			os.Exit(2)
+		}
+		targetReader, err = framedReader(targetReader, targetFraming)
+		if err != nil {
+			slog.Error("invalid target framing", "error", err)
+			os.Exit(2)
+		}
+
+		opts := []multiplexer.Option{
+			multiplexer.WithTargetReader(targetReader),
+			multiplexer.WithTurnaroundDelay(turnaroundDelay),
+			multiplexer.WithMaxRate(maxRate),
+			multiplexer.WithMaxRetryDelay(maxRetryDelay),
+			multiplexer.WithQueueTimeout(queueTimeout),
+			multiplexer.WithFailureThreshold(failureThreshold),
+			multiplexer.WithRequestTimeout(requestTimeout),
+		}
+		if connectTimeout > 0 {
+			opts = append(opts, multiplexer.WithConnectTimeout(connectTimeout))
+		}
+		if responseTimeout > 0 {
+			opts = append(opts, multiplexer.WithResponseTimeout(responseTimeout))
+		}
+		if idleTimeout > 0 {
+			opts = append(opts, multiplexer.WithIdleTimeout(idleTimeout))
+		}
+
+		unitGaps, err := parseUnitGaps(unitGap)
+		if err != nil {
+			slog.Error("invalid unit gap", "error", err)
+			os.Exit(2)
+		}
+		opts = append(opts, multiplexer.WithRequestGap(requestGap, unitGaps))
+
+		if len(extraPorts) > 0 {
+			opts = append(opts, multiplexer.WithExtraPorts(extraPorts...))
+		}
+		if scheduling != "strict" && scheduling != "wfq" {
+			slog.Error("invalid scheduling", "scheduling", scheduling)
+			os.Exit(2)
+		}
+		var clientClasses []multiplexer.Class
+		for _, c := range classes {
+			class, err := multiplexer.ParseClass(c)
+			if err != nil {
+				slog.Error("invalid class", "error", err)
+				os.Exit(2)
+			}
+			clientClasses = append(clientClasses, class)
+		}
+		opts = append(opts,
+			multiplexer.WithClasses(clientClasses, scheduling == "wfq"),
+			multiplexer.WithClientQueueLimit(clientQueue))
+
+		action, err := multiplexer.ParseRateLimitAction(rateLimitAction)
+		if err != nil {
+			slog.Error("invalid rate limit", "error", err)
+			os.Exit(2)
+		}
+		opts = append(opts, multiplexer.WithRateLimits(
+			multiplexer.RateLimit{Requests: clientRate, Bytes: clientByteRate},
+			multiplexer.RateLimit{Requests: ipRate, Bytes: ipByteRate},
+			action))
+
+		allowed, err := parseNetworks(allow)
+		if err != nil {
+			slog.Error("invalid allow list", "error", err)
+			os.Exit(2)
+		}
+		denied, err := parseNetworks(deny)
+		if err != nil {
+			slog.Error("invalid deny list", "error", err)
+			os.Exit(2)
+		}
+		opts = append(opts,
+			multiplexer.WithMaxClients(maxClients, maxClientsPerIP),
+			multiplexer.WithAccessControl(allowed, denied))
+
+		opts = append(opts, multiplexer.WithSessionLease(leaseRequests, leaseTimeout))
+
+		route, err := multiplexer.ParseUnsolicitedRoute(unsolicited)
+		if err != nil {
+			slog.Error("invalid unsolicited route", "error", err)
+			os.Exit(2)
+		}
+		if unsolicited == "" && networkManagement {
+			// answer the host's echo tests
+			route = multiplexer.UnsolicitedRoute{Action: multiplexer.UnsolicitedAnswer}
+		}
+		opts = append(opts, multiplexer.WithUnsolicited(route))
+
+		var masks []multiplexer.MaskRange
+		for _, m := range logMasks {
+			r, err := multiplexer.ParseMaskRange(m)
+			if err != nil {
+				slog.Error("invalid log mask", "error", err)
+				os.Exit(2)
+			}
+			masks = append(masks, r)
+		}
+		opts = append(opts, multiplexer.WithLogMasks(masks...))
+
+		if serial.IsDevice(targetServer) {
+			p, err := serial.ParseParity(parity)
+			if err != nil {
+				slog.Error("invalid serial configuration", "error", err)
+				os.Exit(2)
+			}
+			opts = append(opts, multiplexer.WithSerial(serial.Config{
+				BaudRate: baudRate,
+				DataBits: dataBits,
+				Parity:   p,
+				StopBits: stopBits,
+			}))
+		}
+
+		mux := multiplexer.New(targetServer, port, msgReader, delay, time.Duration(timeout)*time.Second, retryDelay, opts...)
 		go func() {
 			err := mux.Start()
 			if err != nil {
@@ -77,6 +271,17 @@ var serverCmd = &cobra.Command{
 			}
 		}()
 
+		if statusListen != "" {
+			status := http.NewServeMux()
+			status.Handle("/status", mux.StatusHandler())
+			go func() {
+				err := http.ListenAndServe(statusListen, status)
+				if err != nil {
+					slog.Error("status endpoint failed", "error", err)
+				}
+			}()
+		}
+
 		signalChan := make(chan os.Signal, 1)
 		signal.Notify(
 			signalChan,
@@ -86,20 +291,172 @@ var serverCmd = &cobra.Command{
 		)
 		<-signalChan
 
-		err := mux.Close()
+		err = mux.Close()
 		if err != nil {
 			slog.Error(err.Error())
 		}
 	},
 }
 
+// parseUnitGaps parses unit ID to gap mappings such as 1=50ms.
+func parseUnitGaps(gaps map[string]string) (map[byte]time.Duration, error) {
+	unitGaps := make(map[byte]time.Duration, len(gaps))
+	for unit, gap := range gaps {
+		id, err := strconv.ParseUint(unit, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unit ID %q: %w", unit, err)
+		}
+		d, err := time.ParseDuration(gap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gap for unit %d: %w", id, err)
+		}
+		unitGaps[byte(id)] = d
+	}
+	return unitGaps, nil
+}
+
+// parseNetworks parses CIDRs such as 10.0.0.0/24. Single addresses are
+// treated as networks of one address.
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// framedReader applies the framing mode selected for one side of the
+// multiplexer to its message reader.
+func framedReader(reader message.Reader, framing string) (message.Reader, error) {
+	switch framing {
+	case "length":
+		return reader, nil
+	case "silence":
+		if reader.Name() != (message.ModbusSerialMessageReader{}).Name() {
+			return nil, fmt.Errorf("silence framing is not supported by %s", reader.Name())
+		}
+		silence := frameSilence
+		if silence <= 0 {
+			silence = message.ModbusInterFrameDelay(baudRate)
+		}
+		return message.ModbusSilenceMessageReader{Silence: silence}, nil
+	}
+	return nil, fmt.Errorf("unknown framing %q", framing)
+}
+
+// iso8583Reader returns an ISO 8583 reader that uses the field specification
+// and network management given by the iso8583 flags.
+func iso8583Reader(reader message.Reader) (message.Reader, error) {
+	var spec *message.ISO8583Spec
+	if iso8583Spec != "" {
+		data, err := os.ReadFile(iso8583Spec)
+		if err != nil {
+			return nil, err
+		}
+		spec, err = message.ParseISO8583Spec(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var network *message.ISO8583Network
+	if iso8583SignOn != "" || iso8583EchoInterval > 0 {
+		network = &message.ISO8583Network{
+			SignOn:       iso8583SignOn,
+			EchoTest:     iso8583EchoTest,
+			EchoInterval: iso8583EchoInterval,
+			Fields:       make(map[int]string, len(iso8583Fields)),
+		}
+		for field, value := range iso8583Fields {
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 2 || i > 128 {
+				return nil, fmt.Errorf("invalid field number %q", field)
+			}
+			network.Fields[i] = value
+		}
+	}
+
+	var session *message.ISO8583Session
+	if len(iso8583HoldMTIs) > 0 || iso8583HoldField != 0 {
+		if iso8583HoldField != 0 && (iso8583HoldField < 2 || iso8583HoldField > 128) {
+			return nil, fmt.Errorf("invalid field number %d", iso8583HoldField)
+		}
+		session = &message.ISO8583Session{MTIs: iso8583HoldMTIs, Field: iso8583HoldField}
+	}
+
+	switch reader.Name() {
+	case (message.ISO8583MessageReader{}).Name():
+		return message.ISO8583MessageReader{Spec: spec, Network: network, Session: session}, nil
+	case (message.MPUMessageReader{}).Name():
+		return message.MPUMessageReader{Spec: spec, Network: network, Session: session}, nil
+	}
+	return nil, fmt.Errorf("%s is not an ISO 8583 protocol", reader.Name())
+}
+
 func init() {
 	rootCmd.AddCommand(serverCmd)
 
 	serverCmd.Flags().StringVarP(&port, "listen", "l", "8000", "multiplexer will listen on")
-	serverCmd.Flags().StringVarP(&targetServer, "targetServer", "t", "127.0.0.1:1234", "multiplexer will forward message to")
+	serverCmd.Flags().StringSliceVar(&extraPorts, "extraListen", nil, "additional ports the multiplexer will listen on, e.g. to assign classes by port")
+	serverCmd.Flags().StringVarP(&targetServer, "targetServer", "t", "127.0.0.1:1234", "multiplexer will forward message to (host:port or serial device path)")
 	serverCmd.Flags().StringVarP(&applicationProtocol, "applicationProtocol", "p", "echo", "multiplexer will parse to message echo/http/iso8583/modbus")
-	serverCmd.Flags().IntVar(&timeout, "timeout", 60, "timeout in seconds")
+	serverCmd.Flags().StringVar(&targetProtocol, "targetProtocol", "", "protocol spoken by the target server if different from applicationProtocol (e.g. modbus-serial)")
+	serverCmd.Flags().StringVar(&framing, "framing", "length", "how client messages are delimited: length or silence (modbus-serial only)")
+	serverCmd.Flags().StringVar(&targetFraming, "targetFraming", "length", "how target messages are delimited: length or silence (modbus-serial only)")
+	serverCmd.Flags().DurationVar(&frameSilence, "frameSilence", 0, "inter-character silence that ends a frame with silence framing (default 3.5 characters at baudRate)")
+	serverCmd.Flags().DurationVar(&turnaroundDelay, "turnaroundDelay", 100*time.Millisecond, "delay after a broadcast request, which the target does not answer")
+	serverCmd.Flags().DurationVar(&requestGap, "requestGap", 0, "minimum time between a target response and the next request")
+	serverCmd.Flags().StringToStringVar(&unitGap, "unitGap", nil, "minimum time between a response from a unit ID and the next request to it (e.g. 1=50ms,2=100ms)")
+	serverCmd.Flags().Float64Var(&maxRate, "maxRate", 0, "maximum number of requests per second sent to the target (0 for unlimited)")
+	serverCmd.Flags().StringArrayVar(&classes, "class", nil, "client class as name=NAME[,priority=N][,weight=N][,cidr=CIDR...][,port=PORT...], may be repeated; clients get the first matching class")
+	serverCmd.Flags().StringVar(&scheduling, "scheduling", "strict", "how requests of different clients are ordered: strict (class priority) or wfq (weighted fair queuing)")
+	serverCmd.Flags().IntVar(&clientQueue, "clientQueue", 1, "maximum number of requests a client may have queued before the multiplexer stops reading from it")
+	serverCmd.Flags().Float64Var(&clientRate, "clientRate", 0, "maximum number of requests per second of a client (0 for unlimited)")
+	serverCmd.Flags().Float64Var(&clientByteRate, "clientByteRate", 0, "maximum number of request bytes per second of a client (0 for unlimited)")
+	serverCmd.Flags().Float64Var(&ipRate, "ipRate", 0, "maximum number of requests per second of all clients from one source IP (0 for unlimited)")
+	serverCmd.Flags().Float64Var(&ipByteRate, "ipByteRate", 0, "maximum number of request bytes per second of all clients from one source IP (0 for unlimited)")
+	serverCmd.Flags().StringVar(&rateLimitAction, "rateLimitAction", "delay", "what happens to requests exceeding a rate limit: delay, busy (protocol busy error) or disconnect")
+	serverCmd.Flags().IntVar(&maxClients, "maxClients", 0, "maximum number of connected clients (0 for unlimited)")
+	serverCmd.Flags().IntVar(&maxClientsPerIP, "maxClientsPerIP", 0, "maximum number of connected clients per source IP (0 for unlimited)")
+	serverCmd.Flags().StringSliceVar(&allow, "allow", nil, "only accept clients from these networks (CIDR or address, may be repeated)")
+	serverCmd.Flags().StringSliceVar(&deny, "deny", nil, "reject clients from these networks (CIDR or address, may be repeated)")
+	serverCmd.Flags().IntVar(&leaseRequests, "leaseRequests", 0, "consecutive requests a client gets exclusive access to the target for (0 for protocol session markers only)")
+	serverCmd.Flags().DurationVar(&leaseTimeout, "leaseTimeout", 0, "time after which an exclusive session lease is revoked (0 disables leases)")
+	serverCmd.Flags().StringVar(&unsolicited, "unsolicited", "", "route for frames the target sends on its own: drop, broadcast, answer or class:NAME (empty reads only after requests)")
+	serverCmd.Flags().StringVar(&iso8583Spec, "iso8583Spec", "", "JSON file with the ISO 8583 message layout and field specifications (iso8583/mpu only)")
+	serverCmd.Flags().StringVar(&iso8583SignOn, "iso8583SignOn", "", "network management code (field 70) of the 0800 sign-on sent after connecting to the target, e.g. 001")
+	serverCmd.Flags().StringVar(&iso8583EchoTest, "iso8583EchoTest", "301", "network management code (field 70) of 0800 echo tests")
+	serverCmd.Flags().DurationVar(&iso8583EchoInterval, "iso8583EchoInterval", 0, "idle time after which an 0800 echo test is sent to the target (0 disables echo tests)")
+	serverCmd.Flags().StringToStringVar(&iso8583Fields, "iso8583Field", nil, "fields added to 0800 network management requests (e.g. 32=123456)")
+	serverCmd.Flags().StringSliceVar(&iso8583HoldMTIs, "iso8583HoldMTI", nil, "MTIs or MTI prefixes of requests that keep the session lease for the client's next request (e.g. 0100,04)")
+	serverCmd.Flags().IntVar(&iso8583HoldField, "iso8583HoldField", 0, "field whose presence in a request keeps the session lease for the client's next request (0 for none)")
+	serverCmd.Flags().StringSliceVar(&logMasks, "logMask", nil, "byte range OFFSET:LENGTH (or OFFSET: for the rest) masked in every logged frame, may be repeated")
+	serverCmd.Flags().DurationVar(&rawGap, "rawGap", message.DefaultRawIdleGap, "time without bytes in either direction after which a raw client releases the target")
+	serverCmd.Flags().IntVar(&timeout, "timeout", 60, "timeout in seconds, default for the connect, response and idle timeouts")
+	serverCmd.Flags().DurationVar(&connectTimeout, "connectTimeout", 0, "timeout for connecting to the target")
+	serverCmd.Flags().DurationVar(&responseTimeout, "responseTimeout", 0, "timeout for the target to answer a request")
+	serverCmd.Flags().DurationVar(&idleTimeout, "idleTimeout", 0, "time after which idle clients are disconnected")
+	serverCmd.Flags().DurationVar(&requestTimeout, "requestTimeout", 0, "total time a request may take until it is sent to the target, including queueing (0 for unlimited)")
 	serverCmd.Flags().DurationVar(&delay, "delay", 0, "delay after connect")
-	serverCmd.Flags().DurationVar(&retryDelay, "retryDelay", 1*time.Second, "delay before retrying target connection")
+	serverCmd.Flags().DurationVar(&retryDelay, "retryDelay", 1*time.Second, "initial delay before retrying target connection")
+	serverCmd.Flags().DurationVar(&maxRetryDelay, "maxRetryDelay", 30*time.Second, "maximum delay before retrying target connection")
+	serverCmd.Flags().IntVar(&failureThreshold, "failureThreshold", 1, "consecutive target failures that open the circuit breaker")
+	serverCmd.Flags().DurationVar(&queueTimeout, "queueTimeout", 0, "how long requests wait for the target connection to be re-established (0 to fail immediately)")
+	serverCmd.Flags().IntVar(&baudRate, "baudRate", serial.DefaultConfig.BaudRate, "baud rate of a serial target device")
+	serverCmd.Flags().IntVar(&dataBits, "dataBits", serial.DefaultConfig.DataBits, "data bits of a serial target device")
+	serverCmd.Flags().StringVar(&parity, "parity", string(serial.DefaultConfig.Parity), "parity of a serial target device (N/E/O)")
+	serverCmd.Flags().IntVar(&stopBits, "stopBits", serial.DefaultConfig.StopBits, "stop bits of a serial target device")
+	serverCmd.Flags().StringVar(&statusListen, "statusListen", "", "address of an HTTP endpoint serving the circuit state and counters as JSON at /status, e.g. 127.0.0.1:9100")
 }
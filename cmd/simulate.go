@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+	"github.com/ingmarstein/tcp-multiplexer/pkg/serial"
+	"github.com/ingmarstein/tcp-multiplexer/pkg/simulator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	simulateListen         string
+	simulateProtocol       string
+	simulateRegisters      string
+	simulateLatency        time.Duration
+	simulateJitter         time.Duration
+	simulateMaxConnections int
+	simulateExceptionRate  float64
+	simulateException      uint8
+)
+
+// simulateCmd represents the simulate command.
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "simulate target devices for testing",
+}
+
+// simulateModbusCmd represents the simulate modbus command.
+var simulateModbusCmd = &cobra.Command{
+	Use:   "modbus",
+	Short: "simulate Modbus devices serving a register map",
+	Long: `Simulate Modbus devices that serve the coils, discrete inputs, holding and
+input registers of a register map file. Without a register map, unit 1 has
+100 zeroed entries in each table.`,
+	Example: `  tcp-multiplexer simulate modbus -l 1234 --registers registers.json
+  tcp-multiplexer simulate modbus -p modbus-serial -l /dev/ttyUSB0 --baudRate 9600`,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+		if verbose {
+			slog.SetLogLoggerLevel(slog.LevelInfo)
+		}
+		if debug {
+			slog.SetLogLoggerLevel(slog.LevelDebug)
+		}
+
+		reader, ok := message.Readers[simulateProtocol]
+		if !ok || !message.IsModbus(reader) {
+			return fmt.Errorf("%q is not a Modbus protocol", simulateProtocol)
+		}
+
+		units := map[byte]*simulator.Unit{1: simulator.NewUnit(100)}
+		if simulateRegisters != "" {
+			data, err := os.ReadFile(simulateRegisters)
+			if err != nil {
+				return err
+			}
+			units, err = simulator.ParseRegisterMap(data)
+			if err != nil {
+				return fmt.Errorf("invalid register map: %w", err)
+			}
+		}
+
+		s := &simulator.Server{
+			Device: &simulator.Device{
+				Units:         units,
+				ExceptionRate: simulateExceptionRate,
+				Exception:     simulateException,
+			},
+			Reader:         reader,
+			Latency:        simulateLatency,
+			Jitter:         simulateJitter,
+			MaxConnections: simulateMaxConnections,
+		}
+		cmd.SilenceUsage = true
+
+		if serial.IsDevice(simulateListen) {
+			p, err := serial.ParseParity(parity)
+			if err != nil {
+				return err
+			}
+			f, err := serial.Open(simulateListen, serial.Config{
+				BaudRate: baudRate,
+				DataBits: dataBits,
+				Parity:   p,
+				StopBits: stopBits,
+			})
+			if err != nil {
+				return err
+			}
+			slog.Info("simulating Modbus devices", "device", simulateListen, "protocol", simulateProtocol, "units", len(units))
+			s.ServeConn(f)
+			return f.Close()
+		}
+
+		l, err := net.Listen("tcp", ":"+simulateListen)
+		if err != nil {
+			return err
+		}
+		slog.Info("simulating Modbus devices", "port", simulateListen, "protocol", simulateProtocol, "units", len(units))
+		go func() {
+			signalChan := make(chan os.Signal, 1)
+			signal.Notify(signalChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT)
+			<-signalChan
+			if err := s.Close(); err != nil {
+				slog.Error(err.Error())
+			}
+		}()
+		return s.Serve(l)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+	simulateCmd.AddCommand(simulateModbusCmd)
+
+	simulateModbusCmd.Flags().StringVarP(&simulateListen, "listen", "l", "1234", "port to listen on, or a serial device path")
+	simulateModbusCmd.Flags().StringVarP(&simulateProtocol, "applicationProtocol", "p", "modbus", "framing: modbus, modbus-rtu (RTU over TCP), modbus-serial or modbus-ascii")
+	simulateModbusCmd.Flags().StringVar(&simulateRegisters, "registers", "", "JSON file with the coils, discrete inputs, holding and input registers per unit")
+	simulateModbusCmd.Flags().DurationVar(&simulateLatency, "latency", 0, "delay before each response")
+	simulateModbusCmd.Flags().DurationVar(&simulateJitter, "jitter", 0, "maximum random delay added to the latency")
+	simulateModbusCmd.Flags().IntVar(&simulateMaxConnections, "maxConnections", 0, "maximum number of connected clients, further connections are closed (0 for unlimited)")
+	simulateModbusCmd.Flags().Float64Var(&simulateExceptionRate, "exceptionRate", 0, "probability with which a request is answered with an exception (0 to 1)")
+	simulateModbusCmd.Flags().Uint8Var(&simulateException, "exception", 6, "exception code of random exceptions (6 is server device busy)")
+	simulateModbusCmd.Flags().IntVar(&baudRate, "baudRate", serial.DefaultConfig.BaudRate, "baud rate of a serial device")
+	simulateModbusCmd.Flags().IntVar(&dataBits, "dataBits", serial.DefaultConfig.DataBits, "data bits of a serial device")
+	simulateModbusCmd.Flags().StringVar(&parity, "parity", string(serial.DefaultConfig.Parity), "parity of a serial device (N/E/O)")
+	simulateModbusCmd.Flags().IntVar(&stopBits, "stopBits", serial.DefaultConfig.StopBits, "stop bits of a serial device")
+}
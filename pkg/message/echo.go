@@ -3,6 +3,7 @@ package message
 import (
 	"bufio"
 	"io"
+	"strings"
 )
 
 // https://tools.ietf.org/html/rfc862
@@ -18,3 +19,8 @@ func (e EchoMessageReader) Name() string {
 func (e EchoMessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
 	return bufio.NewReader(conn).ReadBytes('\n')
 }
+
+// Describe returns the text of a line.
+func (e EchoMessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	return []Field{{Name: "text", Value: strings.TrimRight(string(frame), "\r\n")}}, nil
+}
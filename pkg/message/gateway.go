@@ -0,0 +1,73 @@
+package message
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Converter translates frames between the protocol spoken by the clients and
+// the protocol spoken by the target server, turning the multiplexer into a
+// protocol gateway.
+type Converter interface {
+	// Request converts a request read from a client into the target framing.
+	Request(req []byte) ([]byte, error)
+	// Response converts a response read from the target into the client
+	// framing. req is the original client request the response belongs to.
+	Response(req, resp []byte) ([]byte, error)
+}
+
+// NewConverter returns a Converter from the client protocol to the target
+// protocol. It fails if the multiplexer cannot translate between the two.
+func NewConverter(client, target Reader) (Converter, error) {
+	c, cok := client.(modbusFramer)
+	t, tok := target.(modbusFramer)
+	if cok && tok {
+		return &modbusConverter{client: c, target: t}, nil
+	}
+
+	return nil, fmt.Errorf("no conversion from %s to %s", client.Name(), target.Name())
+}
+
+// modbusConverter translates between the Modbus transports. Transaction IDs
+// are generated for targets that need one when the client framing has none,
+// and restored from the client request on the way back.
+type modbusConverter struct {
+	client        modbusFramer
+	target        modbusFramer
+	transactionID atomic.Uint32
+}
+
+func (m *modbusConverter) Request(req []byte) ([]byte, error) {
+	adu, err := m.client.decodeADU(req)
+	if err != nil {
+		return nil, err
+	}
+	if !hasTransactionID(m.client) {
+		adu.transactionID = uint16(m.transactionID.Add(1))
+	}
+	return m.target.encodeADU(adu), nil
+}
+
+func (m *modbusConverter) Response(req, resp []byte) ([]byte, error) {
+	reqADU, err := m.client.decodeADU(req)
+	if err != nil {
+		return nil, err
+	}
+	respADU, err := m.target.decodeADU(resp)
+	if err != nil {
+		return nil, err
+	}
+	if respADU.unitID != reqADU.unitID {
+		return nil, fmt.Errorf("%w: response from unit %d to request for unit %d", ErrProtocol, respADU.unitID, reqADU.unitID)
+	}
+	respADU.transactionID = reqADU.transactionID
+	return m.client.encodeADU(respADU), nil
+}
+
+func hasTransactionID(f modbusFramer) bool {
+	switch f.(type) {
+	case ModbusMessageReader, *ModbusMessageReader, ModbusRTUMessageReader, *ModbusRTUMessageReader:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewConverter_Unsupported(t *testing.T) {
+	if _, err := NewConverter(&ModbusMessageReader{}, &HTTPMessageReader{}); err == nil {
+		t.Fatal("Expected an error converting modbus to http")
+	}
+}
+
+func TestModbusConverter_TCPToSerial(t *testing.T) {
+	conv, err := NewConverter(&ModbusMessageReader{}, &ModbusSerialMessageReader{})
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+
+	// Trans(0x1234), Proto(0), Len(6), Unit(1), Read Holding Registers 0x0000, count 1
+	req := []byte{0x12, 0x34, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	got, err := conv.Request(req)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	want := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Request() got = %x, want %x", got, want)
+	}
+
+	resp := encodeRTU(0x01, []byte{0x03, 0x02, 0x00, 0x2A})
+	got, err = conv.Response(req, resp)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	want = []byte{0x12, 0x34, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2A}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Response() got = %x, want %x", got, want)
+	}
+
+	resp[len(resp)-1] ^= 0xFF
+	if _, err = conv.Response(req, resp); err == nil {
+		t.Fatal("Expected CRC error")
+	}
+
+	if _, err = conv.Response(req, encodeRTU(0x02, []byte{0x03, 0x02, 0x00, 0x2A})); err == nil {
+		t.Fatal("Expected unit mismatch error")
+	}
+}
+
+func TestModbusConverter_SerialToTCP(t *testing.T) {
+	conv, err := NewConverter(&ModbusSerialMessageReader{}, &ModbusMessageReader{})
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+
+	req := encodeRTU(0x05, []byte{0x06, 0x00, 0x10, 0x00, 0x03})
+	got, err := conv.Request(req)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	want := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x05, 0x06, 0x00, 0x10, 0x00, 0x03}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Request() got = %x, want %x", got, want)
+	}
+
+	resp := bytes.Clone(got)
+	got, err = conv.Response(req, resp)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	if !bytes.Equal(got, req) {
+		t.Fatalf("Response() got = %x, want %x", got, req)
+	}
+}
+
+func TestModbusConverter_TCPToRTUOverTCP(t *testing.T) {
+	conv, err := NewConverter(&ModbusMessageReader{}, &ModbusRTUMessageReader{})
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+
+	req := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	got, err := conv.Request(req)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	msg, err := ModbusRTUMessageReader{}.ReadMessage(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal("Expected a valid RTU over TCP frame, but got:", err)
+	}
+	if !bytes.Equal(msg, got) {
+		t.Fatalf("ReadMessage() got = %x, want %x", msg, got)
+	}
+}
+
+func TestModbusConverter_ASCIIToTCP(t *testing.T) {
+	conv, err := NewConverter(&ModbusASCIIMessageReader{}, &ModbusMessageReader{})
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+
+	req := []byte(":1103006B00037E\r\n")
+	got, err := conv.Request(req)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	want := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x6B, 0x00, 0x03}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Request() got = %x, want %x", got, want)
+	}
+
+	resp := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x11, 0x03, 0x02, 0x00, 0x2A}
+	got, err = conv.Response(req, resp)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	want = []byte(":110302002AC0\r\n")
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Response() got = %q, want %q", got, want)
+	}
+}
@@ -116,6 +116,72 @@ func (H HTTPMessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
 	return msg, err
 }
 
+// headerKeySession asks the multiplexer to keep the target server for the
+// client's next request when set to "hold".
+const headerKeySession = "X-Multiplexer-Session"
+
+// HoldSession reports whether req carries the X-Multiplexer-Session: hold
+// header.
+func (H HTTPMessageReader) HoldSession(req []byte) bool {
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(req)))
+	if _, err := tp.ReadLine(); err != nil {
+		return false
+	}
+	headers, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(headers.Get(headerKeySession), "hold")
+}
+
+// Busy answers req with 429 Too Many Requests.
+func (H HTTPMessageReader) Busy(req []byte) ([]byte, error) {
+	return []byte("HTTP/1.1 429 Too Many Requests" + CRLF +
+		"Retry-After: 1" + CRLF +
+		headerKeyContentLength + ": 0" + CRLF + CRLF), nil
+}
+
+// Describe returns the method and path of a request, or the status of a
+// response, along with the content type and length.
+func (H HTTPMessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(frame)))
+	startLine, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	headers, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []Field
+	parts := strings.SplitN(startLine, " ", 3)
+	if strings.HasPrefix(startLine, "HTTP/") {
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("malformed status line %q", startLine)
+		}
+		status, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed status line %q", startLine)
+		}
+		fields = append(fields, Field{Name: "version", Value: parts[0]}, Field{Name: "status", Value: status})
+		if len(parts) == 3 {
+			fields = append(fields, Field{Name: "reason", Value: parts[2]})
+		}
+	} else {
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("malformed request line %q", startLine)
+		}
+		fields = append(fields, Field{Name: "method", Value: parts[0]}, Field{Name: "path", Value: parts[1]}, Field{Name: "version", Value: parts[2]})
+	}
+	for _, key := range []string{headerKeyContentType, headerKeyContentLength} {
+		if v := headers.Get(key); v != "" {
+			fields = append(fields, Field{Name: key, Value: v})
+		}
+	}
+	return fields, nil
+}
+
 func dumpHTTPMessage(startLine string, headers textproto.MIMEHeader, body []byte) []byte {
 	var b bytes.Buffer
 	b.WriteString(startLine)
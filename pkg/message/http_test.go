@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -42,3 +43,71 @@ func TestHTTPMessageReader_ReadMessage(t *testing.T) {
 		log.Fatal(err)
 	}
 }
+
+func TestHTTPMessageReader_HoldSession(t *testing.T) {
+	tests := []struct {
+		name string
+		req  string
+		want bool
+	}{
+		{name: "hold", req: "POST /config HTTP/1.1\r\nHost: plc\r\nX-Multiplexer-Session: hold\r\n\r\n", want: true},
+		{name: "no header", req: "GET / HTTP/1.1\r\nHost: plc\r\n\r\n", want: false},
+		{name: "other value", req: "GET / HTTP/1.1\r\nX-Multiplexer-Session: release\r\n\r\n", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (HTTPMessageReader{}).HoldSession([]byte(tt.req)); got != tt.want {
+				t.Errorf("HoldSession() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPMessageReader_Describe(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		want    []Field
+		wantErr bool
+	}{
+		{
+			name:  "request",
+			frame: "POST /api/v1 HTTP/1.1\r\nContent-Type: application/json\r\nContent-Length: 2\r\n\r\n{}",
+			want: []Field{
+				{Name: "method", Value: "POST"},
+				{Name: "path", Value: "/api/v1"},
+				{Name: "version", Value: "HTTP/1.1"},
+				{Name: "Content-Type", Value: "application/json"},
+				{Name: "Content-Length", Value: "2"},
+			},
+		},
+		{
+			name:  "response",
+			frame: "HTTP/1.1 429 Too Many Requests\r\nContent-Length: 0\r\n\r\n",
+			want: []Field{
+				{Name: "version", Value: "HTTP/1.1"},
+				{Name: "status", Value: 429},
+				{Name: "reason", Value: "Too Many Requests"},
+				{Name: "Content-Length", Value: "0"},
+			},
+		},
+		{
+			name:    "malformed",
+			frame:   "HTTP/1.1 OK\r\n\r\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HTTPMessageReader{}.Describe([]byte(tt.frame), true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Describe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Describe() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
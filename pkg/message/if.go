@@ -1,6 +1,14 @@
 package message
 
-import "io"
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrProtocol is wrapped by the errors for frames that violate the protocol,
+// e.g. a CRC mismatch, as opposed to errors of the connection.
+var ErrProtocol = errors.New("protocol error")
 
 // Reader read message for specified application protocol from client and target server.
 type Reader interface {
@@ -8,8 +16,165 @@ type Reader interface {
 	Name() string
 }
 
+// DirectionalReader is implemented by readers whose framing differs between
+// requests and responses, so that they can be told which side they read from.
+type DirectionalReader interface {
+	Reader
+	// ReadRequest reads a request sent by a client.
+	ReadRequest(conn io.Reader) ([]byte, error)
+	// ReadResponse reads a response sent by the target server.
+	ReadResponse(conn io.Reader) ([]byte, error)
+}
+
+// Broadcaster is implemented by readers of protocols in which some requests
+// are never answered by the target server.
+type Broadcaster interface {
+	// IsBroadcast reports whether req is a broadcast request that the target
+	// server does not answer.
+	IsBroadcast(req []byte) bool
+}
+
+// Responses is the number of response frames the target server sends to a
+// request.
+type Responses int
+
+const (
+	// OneResponse requests are answered by a single response frame.
+	OneResponse Responses = iota
+	// NoResponse requests are never answered.
+	NoResponse
+	// UntilFinal requests are answered by response frames up to and
+	// including a final one.
+	UntilFinal
+)
+
+// Exchanger is implemented by readers of protocols in which requests are not
+// always answered by exactly one response frame.
+type Exchanger interface {
+	// Responses returns how many response frames the target server sends to
+	// req.
+	Responses(req []byte) Responses
+	// IsFinal reports whether resp is the last response frame to req.
+	IsFinal(req, resp []byte) bool
+}
+
+// Acknowledger is implemented by readers whose clients expect a reply even to
+// requests that the target server does not answer.
+type Acknowledger interface {
+	// Acknowledge returns the reply to a request that was broadcast.
+	Acknowledge(req []byte) ([]byte, error)
+}
+
+// BusyResponder is implemented by readers of protocols that can tell a client
+// to retry a request later.
+type BusyResponder interface {
+	// Busy returns the reply rejecting req because the server is busy.
+	Busy(req []byte) ([]byte, error)
+}
+
+// SessionHolder is implemented by readers of protocols in which a request can
+// ask for the client's next request to follow it without requests of other
+// clients in between.
+type SessionHolder interface {
+	// HoldSession reports whether req asks to keep exclusive access to the
+	// target server.
+	HoldSession(req []byte) bool
+}
+
+// Streamer is implemented by readers of protocols without message
+// boundaries. Their clients get exclusive access to the target server until
+// no bytes have been sent in either direction for the idle gap.
+type Streamer interface {
+	IdleGap() time.Duration
+}
+
+// Correlator is implemented by readers of protocols in which responses can be
+// told apart from frames the target server sends on its own.
+type Correlator interface {
+	// Matches reports whether resp is a response to req.
+	Matches(req, resp []byte) bool
+}
+
+// Answerer is implemented by readers of protocols in which the multiplexer
+// can answer frames the target server sends on its own, e.g. keepalives.
+type Answerer interface {
+	// Answer returns the reply to frame, or nil if it needs none.
+	Answer(frame []byte) ([]byte, error)
+}
+
+// LinkManager is implemented by readers of protocols in which the target
+// server expects the multiplexer to manage the link, e.g. by signing on after
+// connecting and by sending echo tests while the link is idle.
+type LinkManager interface {
+	// SignOn returns the request to send after connecting, or nil if none.
+	SignOn() ([]byte, error)
+	// KeepAlive returns the request to send once the link has been idle for
+	// KeepAliveInterval, or nil if none.
+	KeepAlive() ([]byte, error)
+	KeepAliveInterval() time.Duration
+	// Accepted returns an error unless resp is a positive response to req.
+	Accepted(req, resp []byte) error
+}
+
+// Masker is implemented by readers of protocols whose messages carry
+// sensitive data, e.g. card numbers, that must not end up in logs.
+type Masker interface {
+	// Mask returns a copy of frame without its sensitive data.
+	Mask(frame []byte) []byte
+}
+
+// Field is a decoded field of a frame.
+type Field struct {
+	Name  string
+	Value any
+}
+
+// Describer is implemented by readers that can decode the fields of a frame,
+// e.g. for logs. Sensitive data is masked as by Masker.
+type Describer interface {
+	// Describe returns the fields of a request or a response frame.
+	Describe(frame []byte, request bool) ([]Field, error)
+}
+
+// Addresser is implemented by readers of protocols in which requests are
+// addressed to one of several devices behind the target server.
+type Addresser interface {
+	// UnitID returns the device req is addressed to.
+	UnitID(req []byte) (byte, bool)
+}
+
 var Readers map[string]Reader
 
+// ReadRequest reads a request from a client with r, using its request framing
+// if it has one.
+func ReadRequest(r Reader, conn io.Reader) ([]byte, error) {
+	if d, ok := r.(DirectionalReader); ok {
+		return d.ReadRequest(conn)
+	}
+	return r.ReadMessage(conn)
+}
+
+// ReadResponse reads a response from the target server with r, using its
+// response framing if it has one.
+func ReadResponse(r Reader, conn io.Reader) ([]byte, error) {
+	if d, ok := r.(DirectionalReader); ok {
+		return d.ReadResponse(conn)
+	}
+	return r.ReadMessage(conn)
+}
+
+// ExpectedResponses returns how many response frames the target server sends
+// to req according to r. Broadcast requests are not answered.
+func ExpectedResponses(r Reader, req []byte) Responses {
+	if e, ok := r.(Exchanger); ok {
+		return e.Responses(req)
+	}
+	if b, ok := r.(Broadcaster); ok && b.IsBroadcast(req) {
+		return NoResponse
+	}
+	return OneResponse
+}
+
 func init() {
 	Readers = make(map[string]Reader)
 	for _, msgReader := range []Reader{
@@ -20,6 +185,9 @@ func init() {
 		&ModbusMessageReader{},
 		&ModbusRTUMessageReader{},
 		&ModbusSerialMessageReader{},
+		&ModbusASCIIMessageReader{},
+		&RawMessageReader{},
+		&SCPIMessageReader{},
 	} {
 		Readers[msgReader.Name()] = msgReader
 	}
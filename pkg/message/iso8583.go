@@ -3,10 +3,21 @@ package message
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"maps"
+	"math"
+	"slices"
+	"time"
 )
 
 type ISO8583MessageReader struct {
+	// Spec is the message layout, DefaultISO8583Spec if nil.
+	Spec *ISO8583Spec
+	// Network configures sign-on and echo tests, none if nil.
+	Network *ISO8583Network
+	// Session marks requests that hold the session lease, none if nil.
+	Session *ISO8583Session
 }
 
 func (I ISO8583MessageReader) Name() string {
@@ -37,3 +48,174 @@ func (I ISO8583MessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
 
 	return append(header, isoMsg...), nil
 }
+
+func (I ISO8583MessageReader) spec() *ISO8583Spec {
+	return I.Spec
+}
+
+func (I ISO8583MessageReader) prefixLength() int {
+	return 2
+}
+
+// Decode decodes a message including its length prefix.
+func (I ISO8583MessageReader) Decode(frame []byte) (*ISO8583Message, error) {
+	return decodeISO8583(I, frame)
+}
+
+// Matches reports whether resp is the response to req, see
+// ISO8583Message.Answers.
+func (I ISO8583MessageReader) Matches(req, resp []byte) bool {
+	return iso8583Matches(I, req, resp)
+}
+
+// iso8583Framer is implemented by the ISO 8583 readers, which differ in the
+// length prefix of their transport.
+type iso8583Framer interface {
+	Reader
+	spec() *ISO8583Spec
+	prefixLength() int
+}
+
+func decodeISO8583(f iso8583Framer, frame []byte) (*ISO8583Message, error) {
+	if len(frame) < f.prefixLength() {
+		return nil, fmt.Errorf("frame too short (%d bytes)", len(frame))
+	}
+	return specOf(f).Unpack(frame[f.prefixLength():])
+}
+
+func iso8583Matches(f iso8583Framer, req, resp []byte) bool {
+	reqMsg, err := decodeISO8583(f, req)
+	if err != nil {
+		return false
+	}
+	respMsg, err := decodeISO8583(f, resp)
+	if err != nil {
+		return false
+	}
+	return respMsg.Answers(reqMsg)
+}
+
+func specOf(f iso8583Framer) *ISO8583Spec {
+	if spec := f.spec(); spec != nil {
+		return spec
+	}
+	return DefaultISO8583Spec
+}
+
+// iso8583KeyFields are described by name.
+var iso8583KeyFields = []struct {
+	field int
+	name  string
+}{
+	{2, "pan"},
+	{3, "processingCode"},
+	{4, "amount"},
+	{iso8583FieldSTAN, "stan"},
+	{iso8583FieldRRN, "rrn"},
+	{38, "authorizationCode"},
+	{iso8583FieldResponseCode, "responseCode"},
+	{41, "terminal"},
+	{42, "merchant"},
+	{49, "currency"},
+	{iso8583FieldNetworkCode, "networkCode"},
+}
+
+// describeISO8583 returns the MTI, the key fields without card data and the
+// numbers of all fields present.
+func describeISO8583(f iso8583Framer, frame []byte) ([]Field, error) {
+	m, err := decodeISO8583(f, frame)
+	if err != nil {
+		return nil, err
+	}
+	present := slices.Sorted(maps.Keys(m.Fields))
+	m = specOf(f).Redact(m)
+	fields := []Field{{Name: "mti", Value: m.MTI}}
+	for _, k := range iso8583KeyFields {
+		if v, ok := m.Fields[k.field]; ok {
+			fields = append(fields, Field{Name: k.name, Value: v})
+		}
+	}
+	return append(fields, Field{Name: "fields", Value: present}), nil
+}
+
+// iso8583Mask re-encodes a frame without its card data. Frames that cannot be
+// decoded are masked entirely after the length prefix, as there is no telling
+// where their card data is.
+func iso8583Mask(f iso8583Encoder, frame []byte) []byte {
+	m, err := decodeISO8583(f, frame)
+	if err == nil {
+		var masked []byte
+		masked, err = encodeISO8583(f, specOf(f).Redact(m))
+		if err == nil {
+			return masked
+		}
+	}
+	masked := bytes.Clone(frame)
+	for i := min(f.prefixLength(), len(masked)); i < len(masked); i++ {
+		masked[i] = '*'
+	}
+	return masked
+}
+
+func (I ISO8583MessageReader) network() *ISO8583Network {
+	return I.Network
+}
+
+func (I ISO8583MessageReader) frame(msg []byte) ([]byte, error) {
+	if len(msg) > math.MaxUint16 {
+		return nil, fmt.Errorf("message too long (%d bytes)", len(msg))
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...), nil
+}
+
+// Encode encodes a message including its length prefix.
+func (I ISO8583MessageReader) Encode(m *ISO8583Message) ([]byte, error) {
+	return encodeISO8583(I, m)
+}
+
+// SignOn returns the configured sign-on request.
+func (I ISO8583MessageReader) SignOn() ([]byte, error) {
+	if I.Network == nil {
+		return nil, nil
+	}
+	return iso8583NetworkRequest(I, I.Network.SignOn)
+}
+
+// KeepAlive returns an echo test request.
+func (I ISO8583MessageReader) KeepAlive() ([]byte, error) {
+	if I.Network == nil {
+		return nil, nil
+	}
+	return iso8583NetworkRequest(I, I.Network.EchoTest)
+}
+
+// KeepAliveInterval returns the configured echo test interval.
+func (I ISO8583MessageReader) KeepAliveInterval() time.Duration {
+	return iso8583KeepAliveInterval(I)
+}
+
+// Accepted returns an error unless resp approves req with response code 00.
+func (I ISO8583MessageReader) Accepted(req, resp []byte) error {
+	return iso8583Accepted(I, req, resp)
+}
+
+// Answer approves network management requests (x8x0) of the host.
+func (I ISO8583MessageReader) Answer(frame []byte) ([]byte, error) {
+	return iso8583Answer(I, frame)
+}
+
+// Mask masks the PAN and removes track data, the PIN block and other fields
+// the spec marks for redaction.
+func (I ISO8583MessageReader) Mask(frame []byte) []byte {
+	return iso8583Mask(I, frame)
+}
+
+// Describe returns the MTI and key fields without card data.
+func (I ISO8583MessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	return describeISO8583(I, frame)
+}
+
+// HoldSession reports whether req is marked to hold the session by Session.
+func (I ISO8583MessageReader) HoldSession(req []byte) bool {
+	return iso8583HoldSession(I, I.Session, req)
+}
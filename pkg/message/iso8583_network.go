@@ -0,0 +1,116 @@
+package message
+
+import (
+	"fmt"
+	"maps"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	iso8583FieldTransmissionTime = 7
+	iso8583FieldResponseCode     = 39
+	iso8583FieldNetworkCode      = 70
+
+	iso8583MTINetworkRequest = "0800"
+	iso8583ResponseApproved  = "00"
+)
+
+// ISO8583Network configures the network management messages (0800) the
+// multiplexer exchanges with an ISO 8583 host.
+type ISO8583Network struct {
+	// SignOn is the network management information code (field 70) of the
+	// sign-on sent after connecting, e.g. "001". No sign-on is sent if empty.
+	SignOn string
+	// EchoTest is the network management information code of echo tests,
+	// e.g. "301". No echo tests are sent if empty or EchoInterval is 0.
+	EchoTest     string
+	EchoInterval time.Duration
+	// Fields are added to every network management request, e.g. the
+	// acquiring institution identification code (field 32).
+	Fields map[int]string
+
+	stan atomic.Uint32
+}
+
+// request returns a network management request with the next STAN.
+func (n *ISO8583Network) request(code string) *ISO8583Message {
+	fields := maps.Clone(n.Fields)
+	if fields == nil {
+		fields = make(map[int]string)
+	}
+	fields[iso8583FieldTransmissionTime] = time.Now().UTC().Format("0102150405")
+	fields[iso8583FieldSTAN] = fmt.Sprintf("%06d", n.stan.Add(1)%1000000)
+	fields[iso8583FieldNetworkCode] = code
+	return &ISO8583Message{MTI: iso8583MTINetworkRequest, Fields: fields}
+}
+
+// iso8583Encoder is implemented by the ISO 8583 readers to add the length
+// prefix of their transport to a message.
+type iso8583Encoder interface {
+	iso8583Framer
+	network() *ISO8583Network
+	frame(msg []byte) ([]byte, error)
+}
+
+func encodeISO8583(f iso8583Encoder, m *ISO8583Message) ([]byte, error) {
+	spec := specOf(f)
+	if m.Header == nil {
+		withHeader := *m
+		withHeader.Header = make([]byte, spec.HeaderLength)
+		m = &withHeader
+	}
+	msg, err := spec.Pack(m)
+	if err != nil {
+		return nil, err
+	}
+	return f.frame(msg)
+}
+
+func iso8583NetworkRequest(f iso8583Encoder, code string) ([]byte, error) {
+	if code == "" {
+		return nil, nil
+	}
+	return encodeISO8583(f, f.network().request(code))
+}
+
+func iso8583KeepAliveInterval(f iso8583Encoder) time.Duration {
+	n := f.network()
+	if n == nil || n.EchoTest == "" {
+		return 0
+	}
+	return n.EchoInterval
+}
+
+func iso8583Accepted(f iso8583Encoder, req, resp []byte) error {
+	reqMsg, err := decodeISO8583(f, req)
+	if err != nil {
+		return err
+	}
+	respMsg, err := decodeISO8583(f, resp)
+	if err != nil {
+		return err
+	}
+	if !respMsg.Answers(reqMsg) {
+		return fmt.Errorf("%s is no response to %s", respMsg.MTI, reqMsg.MTI)
+	}
+	if rc := respMsg.Fields[iso8583FieldResponseCode]; rc != iso8583ResponseApproved {
+		return fmt.Errorf("%s declined with response code %q", reqMsg.MTI, rc)
+	}
+	return nil
+}
+
+// iso8583Answer approves a network management request of the host, e.g. an
+// echo test, by echoing its fields with response code 00.
+func iso8583Answer(f iso8583Encoder, frame []byte) ([]byte, error) {
+	m, err := decodeISO8583(f, frame)
+	if err != nil {
+		return nil, err
+	}
+	if !m.IsRequest() || m.MTI[1] != '8' {
+		return nil, fmt.Errorf("cannot answer %s", m.MTI)
+	}
+	resp := &ISO8583Message{Header: m.Header, MTI: m.ResponseMTI(), Fields: maps.Clone(m.Fields)}
+	resp.Fields[iso8583FieldResponseCode] = iso8583ResponseApproved
+	return encodeISO8583(f, resp)
+}
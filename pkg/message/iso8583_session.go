@@ -0,0 +1,42 @@
+package message
+
+import (
+	"slices"
+	"strings"
+)
+
+// ISO8583Session marks the requests of an ISO 8583 client that keep exclusive
+// access to the target server for the client's next request, e.g. an
+// authorization that is followed by its advice or reversal.
+type ISO8583Session struct {
+	// MTIs holds the message type indicators of requests that hold the
+	// session. A prefix holds it for a whole message class, e.g. "01" for
+	// authorizations.
+	MTIs []string
+	// Field holds the session for requests in which the field is present,
+	// none if 0.
+	Field int
+}
+
+// holds reports whether m keeps the session.
+func (s *ISO8583Session) holds(m *ISO8583Message) bool {
+	if s.Field != 0 {
+		if _, ok := m.Fields[s.Field]; ok {
+			return true
+		}
+	}
+	return slices.ContainsFunc(s.MTIs, func(mti string) bool {
+		return strings.HasPrefix(m.MTI, mti)
+	})
+}
+
+func iso8583HoldSession(f iso8583Framer, s *ISO8583Session, req []byte) bool {
+	if s == nil {
+		return false
+	}
+	m, err := decodeISO8583(f, req)
+	if err != nil {
+		return false
+	}
+	return s.holds(m)
+}
@@ -0,0 +1,627 @@
+package message
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ISO8583Encoding is how the MTI, the bitmap, length prefixes or field values
+// of ISO 8583 messages are encoded.
+type ISO8583Encoding int
+
+const (
+	// ISO8583ASCII encodes digits and characters as ASCII, and bitmaps as
+	// hex digits.
+	ISO8583ASCII ISO8583Encoding = iota
+	// ISO8583BCD packs two digits into a byte.
+	ISO8583BCD
+	// ISO8583Binary leaves bytes as they are.
+	ISO8583Binary
+)
+
+func (e ISO8583Encoding) String() string {
+	switch e {
+	case ISO8583ASCII:
+		return "ascii"
+	case ISO8583BCD:
+		return "bcd"
+	case ISO8583Binary:
+		return "binary"
+	}
+	return fmt.Sprintf("ISO8583Encoding(%d)", int(e))
+}
+
+func (e *ISO8583Encoding) UnmarshalText(text []byte) error {
+	for _, v := range []ISO8583Encoding{ISO8583ASCII, ISO8583BCD, ISO8583Binary} {
+		if v.String() == string(text) {
+			*e = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ISO 8583 encoding %q", text)
+}
+
+// ISO8583LengthType is how the length of an ISO 8583 field is determined.
+type ISO8583LengthType int
+
+const (
+	// ISO8583Fixed fields always have their maximum length.
+	ISO8583Fixed ISO8583LengthType = iota
+	// ISO8583LLVar fields are prefixed with a 2 digit length.
+	ISO8583LLVar
+	// ISO8583LLLVar fields are prefixed with a 3 digit length.
+	ISO8583LLLVar
+)
+
+func (l ISO8583LengthType) String() string {
+	switch l {
+	case ISO8583Fixed:
+		return "fixed"
+	case ISO8583LLVar:
+		return "llvar"
+	case ISO8583LLLVar:
+		return "lllvar"
+	}
+	return fmt.Sprintf("ISO8583LengthType(%d)", int(l))
+}
+
+func (l *ISO8583LengthType) UnmarshalText(text []byte) error {
+	for _, v := range []ISO8583LengthType{ISO8583Fixed, ISO8583LLVar, ISO8583LLLVar} {
+		if v.String() == string(text) {
+			*l = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ISO 8583 length type %q", text)
+}
+
+// prefixDigits returns the number of digits of the length prefix.
+func (l ISO8583LengthType) prefixDigits() int {
+	switch l {
+	case ISO8583LLVar:
+		return 2
+	case ISO8583LLLVar:
+		return 3
+	}
+	return 0
+}
+
+// ISO8583Redaction is how a sensitive ISO 8583 field is hidden in logs.
+type ISO8583Redaction int
+
+const (
+	// ISO8583Keep logs the field as it is.
+	ISO8583Keep ISO8583Redaction = iota
+	// ISO8583MaskPAN logs only the first 6 and last 4 digits of a PAN.
+	ISO8583MaskPAN
+	// ISO8583Remove leaves the field out.
+	ISO8583Remove
+)
+
+func (r ISO8583Redaction) String() string {
+	switch r {
+	case ISO8583Keep:
+		return "keep"
+	case ISO8583MaskPAN:
+		return "pan"
+	case ISO8583Remove:
+		return "remove"
+	}
+	return fmt.Sprintf("ISO8583Redaction(%d)", int(r))
+}
+
+func (r *ISO8583Redaction) UnmarshalText(text []byte) error {
+	for _, v := range []ISO8583Redaction{ISO8583Keep, ISO8583MaskPAN, ISO8583Remove} {
+		if v.String() == string(text) {
+			*r = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ISO 8583 redaction %q", text)
+}
+
+// ISO8583Field specifies a data element of ISO 8583 messages.
+type ISO8583Field struct {
+	Name string `json:"name"`
+	// Length is the length of fixed fields and the maximum length of
+	// variable fields, in digits or characters, or in bytes for binary
+	// fields.
+	Length   int               `json:"length"`
+	Type     ISO8583LengthType `json:"type"`
+	Encoding ISO8583Encoding   `json:"encoding"`
+	// Redact hides sensitive data in logs.
+	Redact ISO8583Redaction `json:"redact"`
+}
+
+// ISO8583Spec specifies the layout of ISO 8583 messages after the length
+// prefix of the transport.
+type ISO8583Spec struct {
+	// HeaderLength is the number of bytes before the MTI, e.g. a TPDU.
+	HeaderLength int             `json:"header"`
+	MTI          ISO8583Encoding `json:"mti"`
+	// Bitmap is ISO8583Binary or ISO8583ASCII for hex digits.
+	Bitmap ISO8583Encoding `json:"bitmap"`
+	// LengthPrefix is the encoding of the length of variable fields,
+	// ISO8583ASCII or ISO8583BCD.
+	LengthPrefix ISO8583Encoding      `json:"lengthPrefix"`
+	Fields       map[int]ISO8583Field `json:"fields"`
+}
+
+// ISO8583Message is a decoded ISO 8583 message. Field values are digits or
+// characters, binary fields are hex encoded.
+type ISO8583Message struct {
+	Header []byte
+	MTI    string
+	Fields map[int]string
+}
+
+const (
+	iso8583FieldSTAN = 11
+	iso8583FieldRRN  = 37
+)
+
+// DefaultISO8583Spec is the ASCII variant of ISO 8583:1987 with binary
+// bitmaps.
+var DefaultISO8583Spec = &ISO8583Spec{
+	MTI:          ISO8583ASCII,
+	Bitmap:       ISO8583Binary,
+	LengthPrefix: ISO8583ASCII,
+	Fields:       iso8583Fields1987(),
+}
+
+func iso8583Fields1987() map[int]ISO8583Field {
+	fixed := func(name string, length int) ISO8583Field {
+		return ISO8583Field{Name: name, Length: length}
+	}
+	llvar := func(name string, length int) ISO8583Field {
+		return ISO8583Field{Name: name, Length: length, Type: ISO8583LLVar}
+	}
+	lllvar := func(name string, length int) ISO8583Field {
+		return ISO8583Field{Name: name, Length: length, Type: ISO8583LLLVar}
+	}
+	binary := func(name string, length int) ISO8583Field {
+		return ISO8583Field{Name: name, Length: length, Encoding: ISO8583Binary}
+	}
+
+	fields := map[int]ISO8583Field{
+		2:   llvar("primary account number", 19),
+		3:   fixed("processing code", 6),
+		4:   fixed("amount, transaction", 12),
+		5:   fixed("amount, settlement", 12),
+		6:   fixed("amount, cardholder billing", 12),
+		7:   fixed("transmission date and time", 10),
+		8:   fixed("amount, cardholder billing fee", 8),
+		9:   fixed("conversion rate, settlement", 8),
+		10:  fixed("conversion rate, cardholder billing", 8),
+		11:  fixed("system trace audit number", 6),
+		12:  fixed("time, local transaction", 6),
+		13:  fixed("date, local transaction", 4),
+		14:  fixed("date, expiration", 4),
+		15:  fixed("date, settlement", 4),
+		16:  fixed("date, conversion", 4),
+		17:  fixed("date, capture", 4),
+		18:  fixed("merchant type", 4),
+		19:  fixed("acquiring institution country code", 3),
+		20:  fixed("PAN extended, country code", 3),
+		21:  fixed("forwarding institution country code", 3),
+		22:  fixed("point of service entry mode", 3),
+		23:  fixed("application PAN sequence number", 3),
+		24:  fixed("network international identifier", 3),
+		25:  fixed("point of service condition code", 2),
+		26:  fixed("point of service capture code", 2),
+		27:  fixed("authorizing identification response length", 1),
+		28:  fixed("amount, transaction fee", 9),
+		29:  fixed("amount, settlement fee", 9),
+		30:  fixed("amount, transaction processing fee", 9),
+		31:  fixed("amount, settlement processing fee", 9),
+		32:  llvar("acquiring institution identification code", 11),
+		33:  llvar("forwarding institution identification code", 11),
+		34:  llvar("primary account number, extended", 28),
+		35:  llvar("track 2 data", 37),
+		36:  lllvar("track 3 data", 104),
+		37:  fixed("retrieval reference number", 12),
+		38:  fixed("authorization identification response", 6),
+		39:  fixed("response code", 2),
+		40:  fixed("service restriction code", 3),
+		41:  fixed("card acceptor terminal identification", 8),
+		42:  fixed("card acceptor identification code", 15),
+		43:  fixed("card acceptor name/location", 40),
+		44:  llvar("additional response data", 25),
+		45:  llvar("track 1 data", 76),
+		46:  lllvar("additional data, ISO", 999),
+		47:  lllvar("additional data, national", 999),
+		48:  lllvar("additional data, private", 999),
+		49:  fixed("currency code, transaction", 3),
+		50:  fixed("currency code, settlement", 3),
+		51:  fixed("currency code, cardholder billing", 3),
+		52:  binary("personal identification number data", 8),
+		53:  fixed("security related control information", 16),
+		54:  lllvar("additional amounts", 120),
+		55:  {Name: "ICC data", Length: 999, Type: ISO8583LLLVar, Encoding: ISO8583Binary},
+		64:  binary("message authentication code", 8),
+		65:  binary("tertiary bitmap", 8),
+		66:  fixed("settlement code", 1),
+		67:  fixed("extended payment code", 2),
+		68:  fixed("receiving institution country code", 3),
+		69:  fixed("settlement institution country code", 3),
+		70:  fixed("network management information code", 3),
+		71:  fixed("message number", 4),
+		72:  fixed("message number, last", 4),
+		73:  fixed("date, action", 6),
+		90:  fixed("original data elements", 42),
+		91:  fixed("file update code", 1),
+		92:  fixed("file security code", 2),
+		93:  fixed("response indicator", 5),
+		94:  fixed("service indicator", 7),
+		95:  fixed("replacement amounts", 42),
+		96:  binary("message security code", 8),
+		97:  fixed("amount, net settlement", 17),
+		98:  fixed("payee", 25),
+		99:  llvar("settlement institution identification code", 11),
+		100: llvar("receiving institution identification code", 11),
+		101: llvar("file name", 17),
+		102: llvar("account identification 1", 28),
+		103: llvar("account identification 2", 28),
+		128: binary("message authentication code", 8),
+	}
+	for i := 56; i <= 63; i++ {
+		fields[i] = lllvar("reserved", 999)
+	}
+	for i := 74; i <= 81; i++ {
+		fields[i] = fixed("count", 10)
+	}
+	for i := 82; i <= 89; i++ {
+		fields[i] = fixed("amount", 12+4*((i-82)/4))
+	}
+	for i := 104; i <= 127; i++ {
+		fields[i] = lllvar("reserved", 999)
+	}
+
+	// card data must not end up in logs, including the CVV2/CVC2 that card
+	// schemes carry in private fields 48 and 126
+	for _, i := range []int{2, 34} {
+		f := fields[i]
+		f.Redact = ISO8583MaskPAN
+		fields[i] = f
+	}
+	for _, i := range []int{35, 36, 45, 48, 52, 55, 126} {
+		f := fields[i]
+		f.Redact = ISO8583Remove
+		fields[i] = f
+	}
+	return fields
+}
+
+// ParseISO8583Spec parses a JSON specification. Fields that it specifies
+// replace those of DefaultISO8583Spec, but keep their redaction unless they
+// set one. DefaultISO8583Spec also provides the encodings that are not given,
+// e.g.
+//
+//	{"mti": "bcd", "lengthPrefix": "bcd", "fields": {"2": {"type": "llvar", "length": 19, "encoding": "bcd"}}}
+func ParseISO8583Spec(data []byte) (*ISO8583Spec, error) {
+	spec := *DefaultISO8583Spec
+	spec.Fields = nil
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("invalid ISO 8583 specification: %w", err)
+	}
+	// fields that do not say how they are redacted keep the default, so
+	// that card data stays out of logs
+	var keys struct {
+		Fields map[int]map[string]json.RawMessage `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid ISO 8583 specification: %w", err)
+	}
+	fields := maps.Clone(DefaultISO8583Spec.Fields)
+	for i, f := range spec.Fields {
+		if i < 2 || i > 128 {
+			return nil, fmt.Errorf("invalid ISO 8583 specification: no field %d", i)
+		}
+		if f.Length <= 0 {
+			return nil, fmt.Errorf("invalid ISO 8583 specification: field %d has no length", i)
+		}
+		if _, ok := keys.Fields[i]["redact"]; !ok {
+			f.Redact = fields[i].Redact
+		}
+		fields[i] = f
+	}
+	spec.Fields = fields
+	return &spec, nil
+}
+
+// Unpack decodes an ISO 8583 message without the length prefix of the
+// transport.
+func (s *ISO8583Spec) Unpack(data []byte) (*ISO8583Message, error) {
+	if len(data) < s.HeaderLength {
+		return nil, errors.New("ISO 8583 message shorter than its header")
+	}
+	m := &ISO8583Message{Header: data[:s.HeaderLength], Fields: make(map[int]string)}
+	r := iso8583Reader{data: data, pos: s.HeaderLength}
+
+	var err error
+	m.MTI, err = r.digits(4, s.MTI)
+	if err != nil {
+		return nil, fmt.Errorf("MTI: %w", err)
+	}
+
+	bitmap, err := r.bitmap(s.Bitmap)
+	if err != nil {
+		return nil, err
+	}
+	if bitmap[0]&0x80 != 0 {
+		secondary, err := r.bitmap(s.Bitmap)
+		if err != nil {
+			return nil, err
+		}
+		bitmap = slices.Concat(bitmap, secondary)
+	}
+
+	for i := 2; i <= len(bitmap)*8; i++ {
+		if bitmap[(i-1)/8]&(0x80>>((i-1)%8)) == 0 {
+			continue
+		}
+		f, ok := s.Fields[i]
+		if !ok {
+			return nil, fmt.Errorf("field %d: not specified", i)
+		}
+		m.Fields[i], err = r.field(f, s.LengthPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", i, err)
+		}
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("%d bytes after the last field", len(data)-r.pos)
+	}
+	return m, nil
+}
+
+// Pack encodes an ISO 8583 message without the length prefix of the
+// transport.
+func (s *ISO8583Spec) Pack(m *ISO8583Message) ([]byte, error) {
+	if len(m.Header) != s.HeaderLength {
+		return nil, fmt.Errorf("header has %d bytes, want %d", len(m.Header), s.HeaderLength)
+	}
+	data := append([]byte{}, m.Header...)
+
+	mti, err := encodeISO8583Digits(m.MTI, 4, s.MTI)
+	if err != nil {
+		return nil, fmt.Errorf("MTI: %w", err)
+	}
+	data = append(data, mti...)
+
+	bitmap := make([]byte, 8)
+	for i := range m.Fields {
+		if i < 2 || i > 128 {
+			return nil, fmt.Errorf("field %d: out of range", i)
+		}
+		if i > 64 && len(bitmap) == 8 {
+			bitmap = append(bitmap, make([]byte, 8)...)
+			bitmap[0] |= 0x80
+		}
+		bitmap[(i-1)/8] |= 0x80 >> ((i - 1) % 8)
+	}
+	if s.Bitmap == ISO8583Binary {
+		data = append(data, bitmap...)
+	} else {
+		data = append(data, strings.ToUpper(hex.EncodeToString(bitmap))...)
+	}
+
+	for i := 2; i <= len(bitmap)*8; i++ {
+		v, ok := m.Fields[i]
+		if !ok {
+			continue
+		}
+		f, ok := s.Fields[i]
+		if !ok {
+			return nil, fmt.Errorf("field %d: not specified", i)
+		}
+		data, err = appendISO8583Field(data, f, s.LengthPrefix, v)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", i, err)
+		}
+	}
+	return data, nil
+}
+
+// Redact returns a copy of m without the sensitive data of fields that are
+// to be redacted according to s.
+func (s *ISO8583Spec) Redact(m *ISO8583Message) *ISO8583Message {
+	redacted := &ISO8583Message{Header: m.Header, MTI: m.MTI, Fields: make(map[int]string, len(m.Fields))}
+	for i, v := range m.Fields {
+		f := s.Fields[i]
+		switch f.Redact {
+		case ISO8583Remove:
+			continue
+		case ISO8583MaskPAN:
+			// BCD and binary fields can only hold hex digits
+			mask := "F"
+			if f.Encoding == ISO8583ASCII {
+				mask = "*"
+			}
+			v = maskPAN(v, mask)
+		}
+		redacted.Fields[i] = v
+	}
+	return redacted
+}
+
+// maskPAN keeps the first 6 and last 4 digits of a PAN, and only the last 4
+// of numbers too short for that.
+func maskPAN(pan, mask string) string {
+	keepFirst := 6
+	if len(pan) < 13 {
+		keepFirst = 0
+	}
+	if len(pan) <= keepFirst+4 {
+		return strings.Repeat(mask, len(pan))
+	}
+	return pan[:keepFirst] + strings.Repeat(mask, len(pan)-keepFirst-4) + pan[len(pan)-4:]
+}
+
+// STAN returns the system trace audit number (field 11).
+func (m *ISO8583Message) STAN() string {
+	return m.Fields[iso8583FieldSTAN]
+}
+
+// RRN returns the retrieval reference number (field 37).
+func (m *ISO8583Message) RRN() string {
+	return m.Fields[iso8583FieldRRN]
+}
+
+// IsRequest reports whether the MTI is a request, advice or notification
+// that is answered with the next message function, e.g. 0200 with 0210.
+func (m *ISO8583Message) IsRequest() bool {
+	return len(m.MTI) == 4 && (m.MTI[2]-'0')%2 == 0
+}
+
+// ResponseMTI returns the MTI of the response to a request.
+func (m *ISO8583Message) ResponseMTI() string {
+	return m.MTI[:2] + string(m.MTI[2]+1) + "0"
+}
+
+// Answers reports whether m is the response to req: its MTI is the response
+// MTI of req, and it carries the same STAN and RRN if both messages have them.
+func (m *ISO8583Message) Answers(req *ISO8583Message) bool {
+	if !req.IsRequest() || len(m.MTI) != 4 || m.MTI[:3] != req.ResponseMTI()[:3] {
+		return false
+	}
+	for _, i := range []int{iso8583FieldSTAN, iso8583FieldRRN} {
+		a, aok := req.Fields[i]
+		b, bok := m.Fields[i]
+		if aok && bok && a != b {
+			return false
+		}
+	}
+	return true
+}
+
+// iso8583Reader decodes the parts of an ISO 8583 message in order.
+type iso8583Reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *iso8583Reader) next(n int) ([]byte, error) {
+	if r.pos+n > len(r.data) {
+		return nil, fmt.Errorf("need %d bytes, have %d", n, len(r.data)-r.pos)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *iso8583Reader) bitmap(enc ISO8583Encoding) ([]byte, error) {
+	if enc == ISO8583Binary {
+		b, err := r.next(8)
+		if err != nil {
+			return nil, fmt.Errorf("bitmap: %w", err)
+		}
+		return b, nil
+	}
+	b, err := r.next(16)
+	if err != nil {
+		return nil, fmt.Errorf("bitmap: %w", err)
+	}
+	bitmap, err := hex.DecodeString(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("bitmap: %w", err)
+	}
+	return bitmap, nil
+}
+
+// digits decodes n digits.
+func (r *iso8583Reader) digits(n int, enc ISO8583Encoding) (string, error) {
+	if enc != ISO8583BCD {
+		b, err := r.next(n)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	b, err := r.next((n + 1) / 2)
+	if err != nil {
+		return "", err
+	}
+	// odd numbers of digits are padded with a leading zero
+	return strings.ToUpper(hex.EncodeToString(b))[len(b)*2-n:], nil
+}
+
+func (r *iso8583Reader) field(f ISO8583Field, prefix ISO8583Encoding) (string, error) {
+	length := f.Length
+	if digits := f.Type.prefixDigits(); digits > 0 {
+		l, err := r.digits(digits, prefix)
+		if err != nil {
+			return "", fmt.Errorf("length: %w", err)
+		}
+		length, err = strconv.Atoi(l)
+		if err != nil {
+			return "", fmt.Errorf("length: %w", err)
+		}
+		if length > f.Length {
+			return "", fmt.Errorf("length %d exceeds maximum %d", length, f.Length)
+		}
+	}
+	if f.Encoding == ISO8583Binary {
+		b, err := r.next(length)
+		if err != nil {
+			return "", err
+		}
+		return strings.ToUpper(hex.EncodeToString(b)), nil
+	}
+	return r.digits(length, f.Encoding)
+}
+
+func encodeISO8583Digits(v string, n int, enc ISO8583Encoding) ([]byte, error) {
+	if len(v) != n {
+		return nil, fmt.Errorf("%q has %d digits, want %d", v, len(v), n)
+	}
+	if enc != ISO8583BCD {
+		return []byte(v), nil
+	}
+	if n%2 != 0 {
+		v = "0" + v
+	}
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not numeric", v)
+	}
+	return b, nil
+}
+
+func appendISO8583Field(data []byte, f ISO8583Field, prefix ISO8583Encoding, v string) ([]byte, error) {
+	length := len(v)
+	if f.Encoding == ISO8583Binary {
+		length /= 2
+	}
+	if f.Type == ISO8583Fixed && length != f.Length {
+		return nil, fmt.Errorf("length %d, want %d", length, f.Length)
+	}
+	if length > f.Length {
+		return nil, fmt.Errorf("length %d exceeds maximum %d", length, f.Length)
+	}
+	if digits := f.Type.prefixDigits(); digits > 0 {
+		l, err := encodeISO8583Digits(fmt.Sprintf("%0*d", digits, length), digits, prefix)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, l...)
+	}
+	if f.Encoding == ISO8583Binary {
+		b, err := hex.DecodeString(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, b...), nil
+	}
+	b, err := encodeISO8583Digits(v, length, f.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, b...), nil
+}
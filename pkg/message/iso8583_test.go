@@ -0,0 +1,482 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// iso8583Frame prefixes an ISO 8583 message with its 2-byte length.
+func iso8583Frame(msg []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestISO8583Spec_Unpack(t *testing.T) {
+	bcd := &ISO8583Spec{
+		HeaderLength: 5,
+		MTI:          ISO8583BCD,
+		Bitmap:       ISO8583Binary,
+		LengthPrefix: ISO8583BCD,
+		Fields: map[int]ISO8583Field{
+			2:  {Length: 19, Type: ISO8583LLVar, Encoding: ISO8583BCD},
+			3:  {Length: 6, Encoding: ISO8583BCD},
+			11: {Length: 6, Encoding: ISO8583BCD},
+			41: {Length: 8},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		spec    *ISO8583Spec
+		data    []byte
+		want    *ISO8583Message
+		wantErr bool
+	}{
+		{
+			name: "ascii",
+			spec: DefaultISO8583Spec,
+			data: append(append([]byte("0200"), mustHex(t, "7020000000000000")...), "16"+"4111111111111111"+"000000"+"000000001000"+"000123"...),
+			want: &ISO8583Message{Header: []byte{}, MTI: "0200", Fields: map[int]string{
+				2:  "4111111111111111",
+				3:  "000000",
+				4:  "000000001000",
+				11: "000123",
+			}},
+		},
+		{
+			name: "secondary bitmap",
+			spec: DefaultISO8583Spec,
+			data: append(append([]byte("0800"), mustHex(t, "82200000000000000400000000000000")...), "0919123456000001301"...),
+			want: &ISO8583Message{Header: []byte{}, MTI: "0800", Fields: map[int]string{
+				7:  "0919123456",
+				11: "000001",
+				70: "301",
+			}},
+		},
+		{
+			name: "ascii bitmap",
+			spec: &ISO8583Spec{Bitmap: ISO8583ASCII, Fields: DefaultISO8583Spec.Fields},
+			data: []byte("08100020000000000000000001"),
+			want: &ISO8583Message{Header: []byte{}, MTI: "0810", Fields: map[int]string{11: "000001"}},
+		},
+		{
+			name: "bcd with header",
+			spec: bcd,
+			data: mustHex(t, "6000010000"+"0200"+"6020000000800000"+"16"+"4111111111111111"+"000000"+"000007"+hex.EncodeToString([]byte("TERM0001"))),
+			want: &ISO8583Message{Header: mustHex(t, "6000010000"), MTI: "0200", Fields: map[int]string{
+				2:  "4111111111111111",
+				3:  "000000",
+				11: "000007",
+				41: "TERM0001",
+			}},
+		},
+		{
+			name:    "truncated field",
+			spec:    DefaultISO8583Spec,
+			data:    append(append([]byte("0200"), mustHex(t, "0020000000000000")...), "0001"...),
+			wantErr: true,
+		},
+		{
+			name:    "variable field too long",
+			spec:    DefaultISO8583Spec,
+			data:    append(append([]byte("0200"), mustHex(t, "4000000000000000")...), "2041111111111111111111"...),
+			wantErr: true,
+		},
+		{
+			name:    "unspecified field",
+			spec:    bcd,
+			data:    mustHex(t, "6000010000"+"0200"+"0000000000000001"+"00"),
+			wantErr: true,
+		},
+		{
+			name:    "trailing bytes",
+			spec:    DefaultISO8583Spec,
+			data:    append(append([]byte("0200"), mustHex(t, "0020000000000000")...), "0000011"...),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.spec.Unpack(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unpack() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !bytes.Equal(got.Header, tt.want.Header) || got.MTI != tt.want.MTI || !maps.Equal(got.Fields, tt.want.Fields) {
+				t.Fatalf("Unpack() got = %+v, want %+v", got, tt.want)
+			}
+
+			packed, err := tt.spec.Pack(got)
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if !bytes.Equal(packed, tt.data) {
+				t.Errorf("Pack() got = %x, want %x", packed, tt.data)
+			}
+		})
+	}
+}
+
+func TestISO8583Message_Answers(t *testing.T) {
+	req := &ISO8583Message{MTI: "0200", Fields: map[int]string{11: "000123", 37: "123456789012"}}
+	tests := []struct {
+		name string
+		req  *ISO8583Message
+		resp *ISO8583Message
+		want bool
+	}{
+		{
+			name: "response",
+			req:  req,
+			resp: &ISO8583Message{MTI: "0210", Fields: map[int]string{11: "000123", 37: "123456789012", 39: "00"}},
+			want: true,
+		},
+		{
+			name: "response without RRN",
+			req:  req,
+			resp: &ISO8583Message{MTI: "0210", Fields: map[int]string{11: "000123"}},
+			want: true,
+		},
+		{
+			name: "response to repeat",
+			req:  &ISO8583Message{MTI: "0421", Fields: map[int]string{11: "000005"}},
+			resp: &ISO8583Message{MTI: "0430", Fields: map[int]string{11: "000005"}},
+			want: true,
+		},
+		{
+			name: "wrong MTI",
+			req:  req,
+			resp: &ISO8583Message{MTI: "0110", Fields: map[int]string{11: "000123"}},
+		},
+		{
+			name: "echoed request",
+			req:  req,
+			resp: req,
+		},
+		{
+			name: "other STAN",
+			req:  req,
+			resp: &ISO8583Message{MTI: "0210", Fields: map[int]string{11: "000124", 37: "123456789012"}},
+		},
+		{
+			name: "other RRN",
+			req:  req,
+			resp: &ISO8583Message{MTI: "0210", Fields: map[int]string{11: "000123", 37: "123456789013"}},
+		},
+		{
+			name: "response to response",
+			req:  &ISO8583Message{MTI: "0210"},
+			resp: &ISO8583Message{MTI: "0220"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.resp.Answers(tt.req); got != tt.want {
+				t.Errorf("Answers() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestISO8583MessageReader_Matches(t *testing.T) {
+	pack := func(mti, stan string) []byte {
+		msg, err := DefaultISO8583Spec.Pack(&ISO8583Message{MTI: mti, Fields: map[int]string{11: stan}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iso8583Frame(msg)
+	}
+
+	req := pack("0800", "000001")
+	if !(ISO8583MessageReader{}).Matches(req, pack("0810", "000001")) {
+		t.Error("Expected the response to match")
+	}
+	if (ISO8583MessageReader{}).Matches(req, pack("0810", "000002")) {
+		t.Error("Expected a response with another STAN not to match")
+	}
+	if (ISO8583MessageReader{}).Matches(req, iso8583Frame([]byte("garbage"))) {
+		t.Error("Expected an undecodable response not to match")
+	}
+
+	frame, err := ISO8583MessageReader{}.ReadMessage(bytes.NewReader(req))
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	m, err := ISO8583MessageReader{}.Decode(frame)
+	if err != nil || m.MTI != "0800" || m.STAN() != "000001" {
+		t.Errorf("Decode() got = %+v, %v", m, err)
+	}
+}
+
+func TestISO8583MessageReader_HoldSession(t *testing.T) {
+	pack := func(mti string, fields map[int]string) []byte {
+		msg, err := DefaultISO8583Spec.Pack(&ISO8583Message{MTI: mti, Fields: fields})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iso8583Frame(msg)
+	}
+
+	r := ISO8583MessageReader{Session: &ISO8583Session{MTIs: []string{"01", "0420"}, Field: 48}}
+	tests := []struct {
+		name  string
+		frame []byte
+		want  bool
+	}{
+		{"message class", pack("0100", map[int]string{11: "000001"}), true},
+		{"MTI", pack("0420", map[int]string{11: "000001"}), true},
+		{"other MTI", pack("0200", map[int]string{11: "000001"}), false},
+		{"field", pack("0200", map[int]string{11: "000001", 48: "SESSION"}), true},
+		{"undecodable", iso8583Frame([]byte("garbage")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.HoldSession(tt.frame); got != tt.want {
+				t.Errorf("HoldSession() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if (ISO8583MessageReader{}).HoldSession(pack("0100", map[int]string{11: "000001"})) {
+		t.Error("Expected no request to hold the session without a session configuration")
+	}
+}
+
+func TestParseISO8583Spec(t *testing.T) {
+	spec, err := ParseISO8583Spec([]byte(`{"header": 5, "mti": "bcd", "fields": {"2": {"type": "llvar", "length": 19, "encoding": "bcd", "redact": "pan"}, "48": {"type": "lllvar", "length": 999, "redact": "remove"}}}`))
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	if spec.HeaderLength != 5 || spec.MTI != ISO8583BCD || spec.Bitmap != ISO8583Binary {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+	if f := spec.Fields[2]; f.Type != ISO8583LLVar || f.Encoding != ISO8583BCD || f.Length != 19 || f.Redact != ISO8583MaskPAN {
+		t.Errorf("field 2 got = %+v", f)
+	}
+	if spec.Fields[48].Redact != ISO8583Remove {
+		t.Errorf("field 48 got = %+v", spec.Fields[48])
+	}
+	if spec.Fields[37] != DefaultISO8583Spec.Fields[37] {
+		t.Errorf("field 37 got = %+v, want the default", spec.Fields[37])
+	}
+	if DefaultISO8583Spec.Fields[2].Encoding != ISO8583ASCII {
+		t.Error("Expected the default spec to be unchanged")
+	}
+
+	// overriding card data fields keeps them redacted unless the spec says
+	// otherwise
+	spec, err = ParseISO8583Spec([]byte(`{"fields": {"2": {"type": "llvar", "length": 19, "encoding": "bcd"}, "35": {"type": "llvar", "length": 37, "encoding": "bcd"}, "55": {"type": "lllvar", "length": 255, "encoding": "binary", "redact": "keep"}}}`))
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	if f := spec.Fields[2]; f.Encoding != ISO8583BCD || f.Redact != ISO8583MaskPAN {
+		t.Errorf("field 2 got = %+v", f)
+	}
+	if f := spec.Fields[35]; f.Encoding != ISO8583BCD || f.Redact != ISO8583Remove {
+		t.Errorf("field 35 got = %+v", f)
+	}
+	if f := spec.Fields[55]; f.Redact != ISO8583Keep {
+		t.Errorf("field 55 got = %+v", f)
+	}
+
+	for _, data := range []string{
+		`{"mti": "ebcdic"}`,
+		`{"fields": {"2": {"type": "lvar", "length": 19}}}`,
+		`{"fields": {"1": {"length": 8}}}`,
+		`{"fields": {"3": {}}}`,
+		`{"fields": {"2": {"length": 19, "redact": "hash"}}}`,
+	} {
+		if _, err := ParseISO8583Spec([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %s", data)
+		}
+	}
+}
+
+func TestISO8583Network(t *testing.T) {
+	network := &ISO8583Network{SignOn: "001", EchoTest: "301", EchoInterval: time.Minute, Fields: map[int]string{32: "123456"}}
+	for _, r := range []interface {
+		LinkManager
+		Answerer
+		Decode(frame []byte) (*ISO8583Message, error)
+		Encode(m *ISO8583Message) ([]byte, error)
+	}{
+		ISO8583MessageReader{Network: network},
+		MPUMessageReader{Network: network},
+	} {
+		t.Run(r.(Reader).Name(), func(t *testing.T) {
+			signOn, err := r.SignOn()
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			m, err := r.Decode(signOn)
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if m.MTI != "0800" || m.Fields[70] != "001" || m.Fields[32] != "123456" || len(m.Fields[7]) != 10 {
+				t.Errorf("unexpected sign-on %+v", m)
+			}
+
+			echo, err := r.KeepAlive()
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			e, err := r.Decode(echo)
+			if err != nil || e.Fields[70] != "301" || e.STAN() == m.STAN() {
+				t.Errorf("unexpected echo test %+v, %v", e, err)
+			}
+			if r.KeepAliveInterval() != time.Minute {
+				t.Errorf("KeepAliveInterval() got = %v", r.KeepAliveInterval())
+			}
+
+			approved, err := r.Answer(signOn)
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if err := r.Accepted(signOn, approved); err != nil {
+				t.Error("Expected the answer to be accepted, but got:", err)
+			}
+			a, _ := r.Decode(approved)
+			a.Fields[39] = "91"
+			declined, err := r.Encode(a)
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if err := r.Accepted(signOn, declined); err == nil {
+				t.Error("Expected a declined sign-on to be rejected")
+			}
+			if err := r.Accepted(signOn, echo); err == nil {
+				t.Error("Expected a request not to be accepted as response")
+			}
+
+			auth, err := r.Encode(&ISO8583Message{MTI: "0200", Fields: map[int]string{11: "000001"}})
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if _, err := r.Answer(auth); err == nil {
+				t.Error("Expected an authorization not to be answered")
+			}
+		})
+	}
+
+	r := ISO8583MessageReader{}
+	if req, err := r.SignOn(); req != nil || err != nil {
+		t.Errorf("Expected no sign-on without network management, got %x, %v", req, err)
+	}
+	if r.KeepAliveInterval() != 0 {
+		t.Error("Expected no echo tests without network management")
+	}
+}
+
+func TestISO8583MessageReader_Mask(t *testing.T) {
+	bcd := &ISO8583Spec{
+		MTI:          ISO8583BCD,
+		Bitmap:       ISO8583Binary,
+		LengthPrefix: ISO8583BCD,
+		Fields: map[int]ISO8583Field{
+			2:  {Length: 19, Type: ISO8583LLVar, Encoding: ISO8583BCD, Redact: ISO8583MaskPAN},
+			11: {Length: 6, Encoding: ISO8583BCD},
+			48: {Length: 999, Type: ISO8583LLLVar, Redact: ISO8583Remove},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		reader ISO8583MessageReader
+		fields map[int]string
+		want   map[int]string
+	}{
+		{
+			name:   "card data",
+			reader: ISO8583MessageReader{},
+			fields: map[int]string{
+				2:   "4111111111111111",
+				11:  "000001",
+				35:  "4111111111111111=25121010000012300000",
+				48:  "92123",
+				52:  "0123456789ABCDEF",
+				126: "CVV2=123",
+			},
+			want: map[int]string{2: "411111******1111", 11: "000001"},
+		},
+		{
+			name:   "short PAN",
+			reader: ISO8583MessageReader{},
+			fields: map[int]string{2: "123456789012"},
+			want:   map[int]string{2: "********9012"},
+		},
+		{
+			name:   "bcd",
+			reader: ISO8583MessageReader{Spec: bcd},
+			fields: map[int]string{2: "4111111111111111", 11: "000001", 48: "CVV2=123"},
+			want:   map[int]string{2: "411111FFFFFF1111", 11: "000001"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := tt.reader.Encode(&ISO8583Message{MTI: "0200", Fields: tt.fields})
+			if err != nil {
+				t.Fatal(err)
+			}
+			original := bytes.Clone(frame)
+			got, err := tt.reader.Decode(tt.reader.Mask(frame))
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if !maps.Equal(got.Fields, tt.want) {
+				t.Errorf("Mask() got fields %v, want %v", got.Fields, tt.want)
+			}
+			if !bytes.Equal(frame, original) {
+				t.Error("Expected the frame to be unchanged")
+			}
+		})
+	}
+
+	masked := ISO8583MessageReader{}.Mask(iso8583Frame([]byte("4111111111111111")))
+	if !bytes.Equal(masked, iso8583Frame(bytes.Repeat([]byte("*"), 16))) {
+		t.Errorf("Expected an undecodable frame to be masked entirely, got %q", masked)
+	}
+}
+
+func TestISO8583MessageReader_Describe(t *testing.T) {
+	frame, err := ISO8583MessageReader{}.Encode(&ISO8583Message{MTI: "0200", Fields: map[int]string{
+		2:  "4111111111111111",
+		4:  "000000001000",
+		11: "000123",
+		35: "4111111111111111=25121010000012300000",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ISO8583MessageReader{}.Describe(frame, true)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	want := []Field{
+		{Name: "mti", Value: "0200"},
+		{Name: "pan", Value: "411111******1111"},
+		{Name: "amount", Value: "000000001000"},
+		{Name: "stan", Value: "000123"},
+		{Name: "fields", Value: []int{2, 4, 11, 35}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Describe() got = %v, want %v", got, want)
+	}
+}
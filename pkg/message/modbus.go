@@ -1,9 +1,14 @@
 package message
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"time"
 )
 
 const (
@@ -11,6 +16,10 @@ const (
 	modbusTCPMaxFrameLength = 260
 	// Modbus RTU over TCP adds a 2-byte CRC to the Modbus TCP frame.
 	modbusRTUMaxFrameLength = modbusTCPMaxFrameLength + 2
+	// A serial line frame is at most 256 bytes: Address(1) + PDU(253) + CRC(2).
+	modbusSerialMaxFrameLength = 256
+	// An ASCII frame is ':' + hex encoded Address(1) + PDU(253) + LRC(1) + CRLF.
+	modbusASCIIMaxFrameLength = 1 + 2*(1+253+1) + 2
 
 	modbusFuncReadCoils              = 1
 	modbusFuncReadDiscreteInputs     = 2
@@ -18,12 +27,46 @@ const (
 	modbusFuncReadInputRegisters     = 4
 	modbusFuncWriteSingleCoil        = 5
 	modbusFuncWriteSingleRegister    = 6
+	modbusFuncReadExceptionStatus    = 7
+	modbusFuncDiagnostics            = 8
+	modbusFuncGetCommEventCounter    = 11
+	modbusFuncGetCommEventLog        = 12
 	modbusFuncWriteMultipleCoils     = 15
 	modbusFuncWriteMultipleRegisters = 16
+	modbusFuncReportServerID         = 17
+	modbusFuncReadFileRecord         = 20
+	modbusFuncWriteFileRecord        = 21
+	modbusFuncMaskWriteRegister      = 22
+	modbusFuncReadWriteRegisters     = 23
+	modbusFuncReadFIFOQueue          = 24
+	modbusFuncEncapsulatedInterface  = 43
+
+	modbusMEIReadDeviceID = 14
 
 	modbusExceptionBit = 0x80
+
+	modbusExceptionServerDeviceBusy = 0x06
+
+	// Requests to this unit ID are broadcast to all devices on a serial line.
+	modbusBroadcastUnitID = 0
 )
 
+// modbusADU is a Modbus application data unit stripped of its transport
+// specific framing.
+type modbusADU struct {
+	transactionID uint16
+	unitID        byte
+	pdu           []byte
+}
+
+// modbusFramer is implemented by the Modbus readers so that frames can be
+// translated between the different Modbus transports.
+type modbusFramer interface {
+	Reader
+	decodeADU(frame []byte) (modbusADU, error)
+	encodeADU(adu modbusADU) []byte
+}
+
 type ModbusMessageReader struct {
 }
 
@@ -53,10 +96,372 @@ func (m ModbusSerialMessageReader) Name() string {
 	return "modbus-serial"
 }
 
+// ReadMessage reads a frame without knowing whether it is a request or a
+// response, telling them apart by their CRC. Only the function codes 1-6, 15
+// and 16 are supported.
 func (m ModbusSerialMessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
 	return readModbusSerialMessage(conn)
 }
 
+// ReadRequest reads a request frame sent by a client.
+func (m ModbusSerialMessageReader) ReadRequest(conn io.Reader) ([]byte, error) {
+	return readModbusSerialRequest(conn)
+}
+
+// ReadResponse reads a response frame sent by the target server.
+func (m ModbusSerialMessageReader) ReadResponse(conn io.Reader) ([]byte, error) {
+	return readModbusSerialResponse(conn)
+}
+
+// ModbusASCIIMessageReader reads Modbus ASCII frames: a ':' followed by the
+// hex encoded address, PDU and LRC, terminated by CRLF.
+type ModbusASCIIMessageReader struct {
+}
+
+func (m ModbusASCIIMessageReader) Name() string {
+	return "modbus-ascii"
+}
+
+func (m ModbusASCIIMessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
+	// read byte by byte so that nothing beyond the end of the frame is consumed
+	frame := make([]byte, 0, 64)
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+		if len(frame) == 0 && b[0] != ':' {
+			return nil, fmt.Errorf("%w: frame starts with %q instead of ':'", ErrProtocol, b[0])
+		}
+		frame = append(frame, b[0])
+		if b[0] == '\n' {
+			break
+		}
+		if len(frame) == modbusASCIIMaxFrameLength {
+			return nil, fmt.Errorf("%w: frame longer than %d bytes", ErrProtocol, modbusASCIIMaxFrameLength)
+		}
+	}
+
+	if _, err := m.decodeADU(frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// ModbusSilenceMessageReader reads raw Modbus RTU frames that are delimited by
+// a period of silence on the line instead of by their expected length, so that
+// frames of any function code can be relayed. It needs a connection that
+// supports read deadlines, and clears the read deadline once a frame is
+// complete.
+type ModbusSilenceMessageReader struct {
+	// Silence is the inter-character timeout after which a frame is complete.
+	Silence time.Duration
+}
+
+func (m ModbusSilenceMessageReader) Name() string {
+	return ModbusSerialMessageReader{}.Name()
+}
+
+func (m ModbusSilenceMessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
+	d, ok := conn.(interface{ SetReadDeadline(t time.Time) error })
+	if !ok {
+		return nil, errors.New("silence framing requires a connection with read deadlines")
+	}
+
+	// the first read waits for the start of the frame under the caller's deadline
+	buf := make([]byte, modbusSerialMaxFrameLength+1)
+	n, err := conn.Read(buf)
+	if n == 0 && err != nil {
+		return nil, err
+	}
+
+	for {
+		if n == len(buf) {
+			return nil, fmt.Errorf("%w: frame longer than %d bytes", ErrProtocol, modbusSerialMaxFrameLength)
+		}
+		if err := d.SetReadDeadline(time.Now().Add(m.Silence)); err != nil {
+			return nil, err
+		}
+		k, err := conn.Read(buf[n:])
+		n += k
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// do not leave the expired inter-character deadline behind for the
+	// caller's next read; callers set their own deadline before reading
+	if err := d.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	fullMsg := buf[:n]
+	if len(fullMsg) < 4 {
+		return nil, fmt.Errorf("%w: frame too short (%d bytes)", ErrProtocol, len(fullMsg))
+	}
+	if !checkCRC(fullMsg) {
+		return nil, fmt.Errorf("%w: CRC mismatch", ErrProtocol)
+	}
+	return fullMsg, nil
+}
+
+// ModbusInterFrameDelay returns the silent interval of 3.5 character times
+// that separates Modbus RTU frames at the given baud rate. Above 19200 baud,
+// the specification recommends a fixed value of 1.75ms.
+func ModbusInterFrameDelay(baudRate int) time.Duration {
+	if baudRate <= 0 || baudRate > 19200 {
+		return 1750 * time.Microsecond
+	}
+	// a character on the line is 11 bits: start, 8 data, parity and stop bit
+	return time.Duration(3.5 * 11 * float64(time.Second) / float64(baudRate))
+}
+
+func (m ModbusMessageReader) decodeADU(frame []byte) (modbusADU, error) {
+	if len(frame) < mbapHeaderLength+2 {
+		return modbusADU{}, fmt.Errorf("%w: frame too short (%d bytes)", ErrProtocol, len(frame))
+	}
+	return modbusADU{
+		transactionID: binary.BigEndian.Uint16(frame[0:2]),
+		unitID:        frame[mbapHeaderLength],
+		pdu:           frame[mbapHeaderLength+1:],
+	}, nil
+}
+
+func (m ModbusMessageReader) encodeADU(adu modbusADU) []byte {
+	frame := make([]byte, mbapHeaderLength+1, mbapHeaderLength+1+len(adu.pdu))
+	binary.BigEndian.PutUint16(frame[0:2], adu.transactionID)
+	binary.BigEndian.PutUint16(frame[4:6], uint16(1+len(adu.pdu)))
+	frame[mbapHeaderLength] = adu.unitID
+	return append(frame, adu.pdu...)
+}
+
+func (m ModbusRTUMessageReader) decodeADU(frame []byte) (modbusADU, error) {
+	if len(frame) < mbapHeaderLength+4 {
+		return modbusADU{}, fmt.Errorf("%w: frame too short (%d bytes)", ErrProtocol, len(frame))
+	}
+	if !checkCRC(frame[mbapHeaderLength:]) {
+		return modbusADU{}, fmt.Errorf("%w: CRC mismatch", ErrProtocol)
+	}
+	return modbusADU{
+		transactionID: binary.BigEndian.Uint16(frame[0:2]),
+		unitID:        frame[mbapHeaderLength],
+		pdu:           frame[mbapHeaderLength+1 : len(frame)-2],
+	}, nil
+}
+
+func (m ModbusRTUMessageReader) encodeADU(adu modbusADU) []byte {
+	frame := make([]byte, mbapHeaderLength, mbapHeaderLength+3+len(adu.pdu))
+	binary.BigEndian.PutUint16(frame[0:2], adu.transactionID)
+	binary.BigEndian.PutUint16(frame[4:6], uint16(3+len(adu.pdu)))
+	return append(frame, encodeRTU(adu.unitID, adu.pdu)...)
+}
+
+func (m ModbusSerialMessageReader) decodeADU(frame []byte) (modbusADU, error) {
+	return decodeRTU(frame)
+}
+
+func (m ModbusSerialMessageReader) encodeADU(adu modbusADU) []byte {
+	return encodeRTU(adu.unitID, adu.pdu)
+}
+
+func (m ModbusSilenceMessageReader) decodeADU(frame []byte) (modbusADU, error) {
+	return decodeRTU(frame)
+}
+
+func (m ModbusSilenceMessageReader) encodeADU(adu modbusADU) []byte {
+	return encodeRTU(adu.unitID, adu.pdu)
+}
+
+func (m ModbusASCIIMessageReader) decodeADU(frame []byte) (modbusADU, error) {
+	if len(frame) < 9 || frame[0] != ':' || !bytes.HasSuffix(frame, []byte(CRLF)) {
+		return modbusADU{}, fmt.Errorf("%w: malformed ASCII frame", ErrProtocol)
+	}
+	data := make([]byte, hex.DecodedLen(len(frame)-3))
+	if _, err := hex.Decode(data, frame[1:len(frame)-2]); err != nil {
+		return modbusADU{}, fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	if lrc(data) != 0 {
+		return modbusADU{}, fmt.Errorf("%w: LRC mismatch (got %02x, want %02x)", ErrProtocol, data[len(data)-1], lrc(data[:len(data)-1]))
+	}
+	return modbusADU{
+		unitID: data[0],
+		pdu:    data[1 : len(data)-1],
+	}, nil
+}
+
+func (m ModbusASCIIMessageReader) encodeADU(adu modbusADU) []byte {
+	data := make([]byte, 1, len(adu.pdu)+2)
+	data[0] = adu.unitID
+	data = append(data, adu.pdu...)
+	data = append(data, lrc(data))
+	frame := append([]byte{':'}, bytes.ToUpper(hex.AppendEncode(nil, data))...)
+	return append(frame, CRLF...)
+}
+
+func (m ModbusRTUMessageReader) IsBroadcast(req []byte) bool {
+	return isModbusBroadcast(m, req)
+}
+
+func (m ModbusSerialMessageReader) IsBroadcast(req []byte) bool {
+	return isModbusBroadcast(m, req)
+}
+
+func (m ModbusSilenceMessageReader) IsBroadcast(req []byte) bool {
+	return isModbusBroadcast(m, req)
+}
+
+func (m ModbusASCIIMessageReader) IsBroadcast(req []byte) bool {
+	return isModbusBroadcast(m, req)
+}
+
+func (m ModbusMessageReader) UnitID(req []byte) (byte, bool) {
+	return modbusUnitID(m, req)
+}
+
+func (m ModbusRTUMessageReader) UnitID(req []byte) (byte, bool) {
+	return modbusUnitID(m, req)
+}
+
+func (m ModbusSerialMessageReader) UnitID(req []byte) (byte, bool) {
+	return modbusUnitID(m, req)
+}
+
+func (m ModbusSilenceMessageReader) UnitID(req []byte) (byte, bool) {
+	return modbusUnitID(m, req)
+}
+
+func (m ModbusASCIIMessageReader) UnitID(req []byte) (byte, bool) {
+	return modbusUnitID(m, req)
+}
+
+func modbusUnitID(f modbusFramer, req []byte) (byte, bool) {
+	adu, err := f.decodeADU(req)
+	if err != nil {
+		return 0, false
+	}
+	return adu.unitID, true
+}
+
+// Matches reports whether resp carries the transaction ID of req.
+func (m ModbusMessageReader) Matches(req, resp []byte) bool {
+	return modbusTransactionMatches(m, req, resp)
+}
+
+// Matches reports whether resp carries the transaction ID of req.
+func (m ModbusRTUMessageReader) Matches(req, resp []byte) bool {
+	return modbusTransactionMatches(m, req, resp)
+}
+
+func modbusTransactionMatches(f modbusFramer, req, resp []byte) bool {
+	reqADU, err := f.decodeADU(req)
+	if err != nil {
+		return false
+	}
+	respADU, err := f.decodeADU(resp)
+	if err != nil {
+		return false
+	}
+	return reqADU.transactionID == respADU.transactionID && reqADU.unitID == respADU.unitID
+}
+
+// Acknowledge answers a broadcast write with the response a device would send
+// for a unicast write, since Modbus TCP clients expect a reply to every request.
+func (m ModbusMessageReader) Acknowledge(req []byte) ([]byte, error) {
+	adu, err := m.decodeADU(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(adu.pdu) < 5 {
+		return nil, fmt.Errorf("%w: write request too short (%d bytes)", ErrProtocol, len(adu.pdu))
+	}
+	switch adu.pdu[0] {
+	case modbusFuncWriteMultipleCoils, modbusFuncWriteMultipleRegisters:
+		// Func, Address(2), Quantity(2)
+		adu.pdu = adu.pdu[:5]
+	}
+	return m.encodeADU(adu), nil
+}
+
+func (m ModbusMessageReader) Busy(req []byte) ([]byte, error) {
+	return modbusException(m, req, modbusExceptionServerDeviceBusy)
+}
+
+func (m ModbusRTUMessageReader) Busy(req []byte) ([]byte, error) {
+	return modbusException(m, req, modbusExceptionServerDeviceBusy)
+}
+
+func (m ModbusSerialMessageReader) Busy(req []byte) ([]byte, error) {
+	return modbusException(m, req, modbusExceptionServerDeviceBusy)
+}
+
+func (m ModbusSilenceMessageReader) Busy(req []byte) ([]byte, error) {
+	return modbusException(m, req, modbusExceptionServerDeviceBusy)
+}
+
+func (m ModbusASCIIMessageReader) Busy(req []byte) ([]byte, error) {
+	return modbusException(m, req, modbusExceptionServerDeviceBusy)
+}
+
+// modbusException returns the exception response to req with the given
+// exception code.
+func modbusException(f modbusFramer, req []byte, code byte) ([]byte, error) {
+	adu, err := f.decodeADU(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(adu.pdu) == 0 {
+		return nil, fmt.Errorf("%w: empty request", ErrProtocol)
+	}
+	adu.pdu = []byte{adu.pdu[0] | modbusExceptionBit, code}
+	return f.encodeADU(adu), nil
+}
+
+// isModbusBroadcast reports whether req is a write addressed to all devices on
+// a serial line, which by specification is never answered.
+func isModbusBroadcast(f modbusFramer, req []byte) bool {
+	adu, err := f.decodeADU(req)
+	if err != nil || adu.unitID != modbusBroadcastUnitID || len(adu.pdu) == 0 {
+		return false
+	}
+	switch adu.pdu[0] {
+	case modbusFuncWriteSingleCoil, modbusFuncWriteSingleRegister,
+		modbusFuncWriteMultipleCoils, modbusFuncWriteMultipleRegisters,
+		modbusFuncWriteFileRecord, modbusFuncMaskWriteRegister:
+		return true
+	}
+	return false
+}
+
+// decodeRTU splits a raw RTU frame (address, PDU, CRC) into its parts. RTU
+// frames carry no transaction identifier.
+func decodeRTU(frame []byte) (modbusADU, error) {
+	if len(frame) < 4 {
+		return modbusADU{}, fmt.Errorf("%w: frame too short (%d bytes)", ErrProtocol, len(frame))
+	}
+	if !checkCRC(frame) {
+		return modbusADU{}, fmt.Errorf("%w: CRC mismatch", ErrProtocol)
+	}
+	return modbusADU{
+		unitID: frame[0],
+		pdu:    frame[1 : len(frame)-2],
+	}, nil
+}
+
+// encodeRTU builds a raw RTU frame including the CRC.
+func encodeRTU(unitID byte, pdu []byte) []byte {
+	frame := make([]byte, 1, len(pdu)+3)
+	frame[0] = unitID
+	frame = append(frame, pdu...)
+	return binary.LittleEndian.AppendUint16(frame, crc16(frame))
+}
+
+// ErrMBAPHeader is wrapped by the errors for frames with an invalid MBAP
+// header. The length of such a frame is unknown, so the frames following it
+// cannot be found.
+var ErrMBAPHeader = fmt.Errorf("%w: invalid MBAP header", ErrProtocol)
+
 func readModbusMessage(conn io.Reader, maxFrameLength int, verifyCRC bool) ([]byte, error) {
 	header := make([]byte, mbapHeaderLength)
 	_, err := io.ReadFull(conn, header)
@@ -68,12 +473,12 @@ func readModbusMessage(conn io.Reader, maxFrameLength int, verifyCRC bool) ([]by
 	bytesNeeded := int(binary.BigEndian.Uint16(header[4:6]))
 	// never read more than the max allowed frame length
 	if bytesNeeded+mbapHeaderLength > maxFrameLength {
-		return nil, fmt.Errorf("protocol error: %d larger than max allowed frame length (%d)", bytesNeeded+mbapHeaderLength, maxFrameLength)
+		return nil, fmt.Errorf("%w: length %d larger than max allowed frame length (%d)", ErrMBAPHeader, bytesNeeded+mbapHeaderLength, maxFrameLength)
 	}
 
 	// an MBAP length of 0 is illegal
 	if bytesNeeded <= 0 {
-		return nil, fmt.Errorf("protocol error: illegal MBAP length (%d)", bytesNeeded)
+		return nil, fmt.Errorf("%w: illegal length (%d)", ErrMBAPHeader, bytesNeeded)
 	}
 
 	// read the PDU (and CRC if present)
@@ -87,14 +492,14 @@ func readModbusMessage(conn io.Reader, maxFrameLength int, verifyCRC bool) ([]by
 
 	if verifyCRC {
 		if len(rxbuf) < 4 { // Must have at least Unit ID (1), Function Code (1), and CRC (2)
-			return nil, fmt.Errorf("protocol error: frame too short for CRC, got %d bytes, expected at least 4", len(rxbuf))
+			return nil, fmt.Errorf("%w: frame too short for CRC, got %d bytes, expected at least 4", ErrProtocol, len(rxbuf))
 		}
 		// CRC is calculated over Unit Address and Message (PDU)
 		// These are all bytes after the 6-byte MBAP header, excluding the last 2 bytes (the CRC itself)
 		actualCRC := binary.LittleEndian.Uint16(rxbuf[len(rxbuf)-2:])
 		expectedCRC := crc16(rxbuf[:len(rxbuf)-2])
 		if actualCRC != expectedCRC {
-			return nil, fmt.Errorf("protocol error: CRC mismatch (got %04x, want %04x)", actualCRC, expectedCRC)
+			return nil, fmt.Errorf("%w: CRC mismatch (got %04x, want %04x)", ErrProtocol, actualCRC, expectedCRC)
 		}
 	}
 
@@ -118,7 +523,7 @@ func readModbusSerialMessage(conn io.Reader) ([]byte, error) {
 		}
 		fullMsg := append(head, rest...)
 		if !checkCRC(fullMsg) {
-			return nil, fmt.Errorf("protocol error: CRC mismatch for exception frame")
+			return nil, fmt.Errorf("%w: CRC mismatch for exception frame", ErrProtocol)
 		}
 		return fullMsg, nil
 	}
@@ -176,7 +581,7 @@ func readModbusSerialMessage(conn io.Reader) ([]byte, error) {
 			return fullMsg, nil
 		}
 
-		return nil, fmt.Errorf("protocol error: CRC mismatch for ambiguous frame")
+		return nil, fmt.Errorf("%w: CRC mismatch for ambiguous frame", ErrProtocol)
 
 	case modbusFuncWriteSingleCoil, modbusFuncWriteSingleRegister:
 		// Fixed 8 bytes
@@ -186,7 +591,7 @@ func readModbusSerialMessage(conn io.Reader) ([]byte, error) {
 		}
 		fullMsg = append(head, rest...)
 		if !checkCRC(fullMsg) {
-			return nil, fmt.Errorf("protocol error: CRC mismatch")
+			return nil, fmt.Errorf("%w: CRC mismatch", ErrProtocol)
 		}
 		return fullMsg, nil
 
@@ -228,13 +633,176 @@ func readModbusSerialMessage(conn io.Reader) ([]byte, error) {
 		fullMsg = append(fullMsg, tail...)
 
 		if !checkCRC(fullMsg) {
-			return nil, fmt.Errorf("protocol error: CRC mismatch")
+			return nil, fmt.Errorf("%w: CRC mismatch", ErrProtocol)
 		}
 		return fullMsg, nil
 
 	default:
-		return nil, fmt.Errorf("protocol error: unsupported function code %d", funcCode)
+		return nil, fmt.Errorf("%w: unsupported function code %d", ErrProtocol, funcCode)
+	}
+}
+
+// rtuFrame accumulates a raw RTU frame whose length is determined step by
+// step from the bytes read so far.
+type rtuFrame struct {
+	conn io.Reader
+	buf  []byte
+}
+
+// fill reads until the frame is n bytes long.
+func (f *rtuFrame) fill(n int) error {
+	if n > modbusSerialMaxFrameLength {
+		return fmt.Errorf("%w: frame length %d larger than max allowed frame length (%d)", ErrProtocol, n, modbusSerialMaxFrameLength)
+	}
+	if n <= len(f.buf) {
+		return nil
+	}
+	rest := make([]byte, n-len(f.buf))
+	if _, err := io.ReadFull(f.conn, rest); err != nil {
+		return err
+	}
+	f.buf = append(f.buf, rest...)
+	return nil
+}
+
+// fillCounted reads a frame whose data is preceded by a big-endian byte count
+// of the given width at offset, followed by the CRC.
+func (f *rtuFrame) fillCounted(offset, width int) error {
+	if err := f.fill(offset + width); err != nil {
+		return err
+	}
+	count := 0
+	for _, b := range f.buf[offset : offset+width] {
+		count = count<<8 | int(b)
+	}
+	return f.fill(offset + width + count + 2)
+}
+
+// fillDeviceID reads a Read Device Identification response, which consists of
+// a list of objects each prefixed with its id and length.
+func (f *rtuFrame) fillDeviceID() error {
+	// Addr, Func, MEI, ReadDevIdCode, Conformity, MoreFollows, NextObjectId, NumberOfObjects
+	if err := f.fill(8); err != nil {
+		return err
+	}
+	offset := 8
+	for range int(f.buf[7]) {
+		// ObjectId, ObjectLength
+		if err := f.fill(offset + 2); err != nil {
+			return err
+		}
+		offset += 2 + int(f.buf[offset+1])
+	}
+	return f.fill(offset + 2)
+}
+
+func (f *rtuFrame) verify() ([]byte, error) {
+	if !checkCRC(f.buf) {
+		return nil, fmt.Errorf("%w: CRC mismatch", ErrProtocol)
+	}
+	return f.buf, nil
+}
+
+func readModbusSerialRequest(conn io.Reader) ([]byte, error) {
+	f := &rtuFrame{conn: conn}
+	// Read Address and Function Code
+	if err := f.fill(2); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch funcCode := f.buf[1]; funcCode {
+	case modbusFuncReadCoils, modbusFuncReadDiscreteInputs, modbusFuncReadHoldingRegisters, modbusFuncReadInputRegisters,
+		modbusFuncWriteSingleCoil, modbusFuncWriteSingleRegister:
+		// Addr, Func, Address(2), Quantity/Value(2), CRC
+		err = f.fill(8)
+	case modbusFuncDiagnostics:
+		// Addr, Func, SubFunction(2), Data(2), CRC
+		err = f.fill(8)
+	case modbusFuncReadExceptionStatus, modbusFuncGetCommEventCounter, modbusFuncGetCommEventLog, modbusFuncReportServerID:
+		// Addr, Func, CRC
+		err = f.fill(4)
+	case modbusFuncWriteMultipleCoils, modbusFuncWriteMultipleRegisters:
+		// Addr, Func, Address(2), Quantity(2), ByteCount, Data, CRC
+		err = f.fillCounted(6, 1)
+	case modbusFuncReadFileRecord, modbusFuncWriteFileRecord:
+		// Addr, Func, ByteCount, SubRequests, CRC
+		err = f.fillCounted(2, 1)
+	case modbusFuncMaskWriteRegister:
+		// Addr, Func, Address(2), AndMask(2), OrMask(2), CRC
+		err = f.fill(10)
+	case modbusFuncReadWriteRegisters:
+		// Addr, Func, ReadAddress(2), ReadQuantity(2), WriteAddress(2), WriteQuantity(2), ByteCount, Data, CRC
+		err = f.fillCounted(10, 1)
+	case modbusFuncReadFIFOQueue:
+		// Addr, Func, FIFOAddress(2), CRC
+		err = f.fill(6)
+	case modbusFuncEncapsulatedInterface:
+		if err = f.fill(3); err == nil {
+			if f.buf[2] != modbusMEIReadDeviceID {
+				return nil, fmt.Errorf("%w: unsupported MEI type %d", ErrProtocol, f.buf[2])
+			}
+			// Addr, Func, MEI, ReadDevIdCode, ObjectId, CRC
+			err = f.fill(7)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported function code %d", ErrProtocol, funcCode)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return f.verify()
+}
+
+func readModbusSerialResponse(conn io.Reader) ([]byte, error) {
+	f := &rtuFrame{conn: conn}
+	// Read Address and Function Code
+	if err := f.fill(2); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch funcCode := f.buf[1]; {
+	case funcCode&modbusExceptionBit != 0:
+		// Addr, Func, ExceptionCode, CRC
+		err = f.fill(5)
+	case funcCode == modbusFuncReadCoils, funcCode == modbusFuncReadDiscreteInputs,
+		funcCode == modbusFuncReadHoldingRegisters, funcCode == modbusFuncReadInputRegisters,
+		funcCode == modbusFuncGetCommEventLog, funcCode == modbusFuncReportServerID,
+		funcCode == modbusFuncReadFileRecord, funcCode == modbusFuncWriteFileRecord,
+		funcCode == modbusFuncReadWriteRegisters:
+		// Addr, Func, ByteCount, Data, CRC
+		err = f.fillCounted(2, 1)
+	case funcCode == modbusFuncWriteSingleCoil, funcCode == modbusFuncWriteSingleRegister,
+		funcCode == modbusFuncDiagnostics, funcCode == modbusFuncGetCommEventCounter,
+		funcCode == modbusFuncWriteMultipleCoils, funcCode == modbusFuncWriteMultipleRegisters:
+		// Addr, Func, 4 bytes of data, CRC
+		err = f.fill(8)
+	case funcCode == modbusFuncReadExceptionStatus:
+		// Addr, Func, OutputData, CRC
+		err = f.fill(5)
+	case funcCode == modbusFuncMaskWriteRegister:
+		// Addr, Func, Address(2), AndMask(2), OrMask(2), CRC
+		err = f.fill(10)
+	case funcCode == modbusFuncReadFIFOQueue:
+		// Addr, Func, ByteCount(2), FIFOCount(2), Values, CRC
+		err = f.fillCounted(2, 2)
+	case funcCode == modbusFuncEncapsulatedInterface:
+		if err = f.fill(3); err == nil {
+			if f.buf[2] != modbusMEIReadDeviceID {
+				return nil, fmt.Errorf("%w: unsupported MEI type %d", ErrProtocol, f.buf[2])
+			}
+			err = f.fillDeviceID()
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported function code %d", ErrProtocol, funcCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return f.verify()
 }
 
 func checkCRC(msg []byte) bool {
@@ -246,6 +814,16 @@ func checkCRC(msg []byte) bool {
 	return actual == expected
 }
 
+// lrc calculates the Modbus ASCII longitudinal redundancy check, the two's
+// complement of the sum of all bytes. The LRC of a frame including its LRC is 0.
+func lrc(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
 // crc16 calculates the Modbus CRC-16.
 func crc16(data []byte) uint16 {
 	var crc uint16 = 0xFFFF
@@ -0,0 +1,78 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestModbusASCIIMessageReader_ReadMessage(t *testing.T) {
+	reader := &ModbusASCIIMessageReader{}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		wantErr bool
+	}{
+		{
+			name: "FC03 Request",
+			// Addr(0x11), Func(3), Start(0x006B), Count(3), LRC(0x7E)
+			payload: []byte(":1103006B00037E\r\n"),
+		},
+		{
+			name:    "Lower Case Hex",
+			payload: []byte(":1103006b00037e\r\n"),
+		},
+		{
+			name:    "LRC Error",
+			payload: []byte(":1103006B00037F\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "Missing Start",
+			payload: []byte("1103006B00037E\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "Odd Number Of Hex Digits",
+			payload: []byte(":1103006B00037E0\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "Missing CR",
+			payload: []byte(":1103006B00037E\n"),
+			wantErr: true,
+		},
+		{
+			name:    "Too Long",
+			payload: append(append([]byte{':'}, bytes.Repeat([]byte{'0'}, modbusASCIIMaxFrameLength)...), '\r', '\n'),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := bytes.NewBuffer(append(bytes.Clone(tt.payload), ":01"...))
+			got, err := reader.ReadMessage(conn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadMessage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				if !bytes.Equal(got, tt.payload) {
+					t.Errorf("ReadMessage() got = %q, want %q", got, tt.payload)
+				}
+				if conn.String() != ":01" {
+					t.Errorf("ReadMessage() consumed bytes beyond the frame, left %q", conn.String())
+				}
+			}
+		})
+	}
+}
+
+func TestModbusASCIIMessageReader_EncodeADU(t *testing.T) {
+	got := ModbusASCIIMessageReader{}.encodeADU(modbusADU{unitID: 0x11, pdu: []byte{0x03, 0x00, 0x6B, 0x00, 0x03}})
+	want := []byte(":1103006B00037E\r\n")
+	if !bytes.Equal(got, want) {
+		t.Errorf("encodeADU() got = %q, want %q", got, want)
+	}
+}
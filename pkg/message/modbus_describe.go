@@ -0,0 +1,185 @@
+package message
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+var modbusFunctionNames = map[byte]string{
+	modbusFuncReadCoils:              "read coils",
+	modbusFuncReadDiscreteInputs:     "read discrete inputs",
+	modbusFuncReadHoldingRegisters:   "read holding registers",
+	modbusFuncReadInputRegisters:     "read input registers",
+	modbusFuncWriteSingleCoil:        "write single coil",
+	modbusFuncWriteSingleRegister:    "write single register",
+	modbusFuncReadExceptionStatus:    "read exception status",
+	modbusFuncDiagnostics:            "diagnostics",
+	modbusFuncGetCommEventCounter:    "get comm event counter",
+	modbusFuncGetCommEventLog:        "get comm event log",
+	modbusFuncWriteMultipleCoils:     "write multiple coils",
+	modbusFuncWriteMultipleRegisters: "write multiple registers",
+	modbusFuncReportServerID:         "report server ID",
+	modbusFuncReadFileRecord:         "read file record",
+	modbusFuncWriteFileRecord:        "write file record",
+	modbusFuncMaskWriteRegister:      "mask write register",
+	modbusFuncReadWriteRegisters:     "read/write multiple registers",
+	modbusFuncReadFIFOQueue:          "read FIFO queue",
+	modbusFuncEncapsulatedInterface:  "encapsulated interface transport",
+}
+
+var modbusExceptionNames = map[byte]string{
+	0x01: "illegal function",
+	0x02: "illegal data address",
+	0x03: "illegal data value",
+	0x04: "server device failure",
+	0x05: "acknowledge",
+	0x06: "server device busy",
+	0x08: "memory parity error",
+	0x0A: "gateway path unavailable",
+	0x0B: "gateway target device failed to respond",
+}
+
+func modbusName(names map[byte]string, code byte) string {
+	if name, ok := names[code]; ok {
+		return fmt.Sprintf("%s (%d)", name, code)
+	}
+	return fmt.Sprintf("%d", code)
+}
+
+var errModbusTruncated = fmt.Errorf("%w: PDU too short for its function", ErrProtocol)
+
+// describeModbus decodes the header of a frame and the data of the common
+// function codes. The data of other functions is returned as hex.
+func describeModbus(f modbusFramer, frame []byte, request bool) ([]Field, error) {
+	adu, err := f.decodeADU(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(adu.pdu) == 0 {
+		return nil, fmt.Errorf("%w: empty PDU", ErrProtocol)
+	}
+
+	var fields []Field
+	if hasTransactionID(f) {
+		fields = append(fields, Field{Name: "transaction", Value: adu.transactionID})
+	}
+	fields = append(fields, Field{Name: "unit", Value: adu.unitID})
+
+	code, data := adu.pdu[0], adu.pdu[1:]
+	if code&modbusExceptionBit != 0 {
+		if len(data) < 1 {
+			return nil, errModbusTruncated
+		}
+		return append(fields,
+			Field{Name: "function", Value: modbusName(modbusFunctionNames, code&^modbusExceptionBit)},
+			Field{Name: "exception", Value: modbusName(modbusExceptionNames, data[0])}), nil
+	}
+	fields = append(fields, Field{Name: "function", Value: modbusName(modbusFunctionNames, code)})
+
+	word := func(i int) uint16 {
+		return binary.BigEndian.Uint16(data[i:])
+	}
+	switch {
+	case request && code >= modbusFuncReadCoils && code <= modbusFuncReadInputRegisters:
+		if len(data) < 4 {
+			return nil, errModbusTruncated
+		}
+		return append(fields, Field{Name: "address", Value: word(0)}, Field{Name: "quantity", Value: word(2)}), nil
+
+	case !request && (code == modbusFuncReadCoils || code == modbusFuncReadDiscreteInputs):
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return nil, errModbusTruncated
+		}
+		return append(fields, Field{Name: "bits", Value: modbusBits(data[1 : 1+int(data[0])])}), nil
+
+	case !request && (code == modbusFuncReadHoldingRegisters || code == modbusFuncReadInputRegisters || code == modbusFuncReadWriteRegisters):
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return nil, errModbusTruncated
+		}
+		return append(fields, Field{Name: "values", Value: modbusRegisters(data[1 : 1+int(data[0])])}), nil
+
+	case code == modbusFuncWriteSingleCoil || code == modbusFuncWriteSingleRegister:
+		if len(data) < 4 {
+			return nil, errModbusTruncated
+		}
+		return append(fields, Field{Name: "address", Value: word(0)}, Field{Name: "value", Value: word(2)}), nil
+
+	case code == modbusFuncWriteMultipleCoils || code == modbusFuncWriteMultipleRegisters:
+		if len(data) < 4 {
+			return nil, errModbusTruncated
+		}
+		fields = append(fields, Field{Name: "address", Value: word(0)}, Field{Name: "quantity", Value: word(2)})
+		if !request {
+			return fields, nil
+		}
+		if len(data) < 5 || len(data) < 5+int(data[4]) {
+			return nil, errModbusTruncated
+		}
+		values := data[5 : 5+int(data[4])]
+		if code == modbusFuncWriteMultipleCoils {
+			return append(fields, Field{Name: "bits", Value: modbusBits(values)[:min(int(word(2)), len(values)*8)]}), nil
+		}
+		return append(fields, Field{Name: "values", Value: modbusRegisters(values)}), nil
+
+	case request && code == modbusFuncReadWriteRegisters:
+		if len(data) < 9 || len(data) < 9+int(data[8]) {
+			return nil, errModbusTruncated
+		}
+		return append(fields,
+			Field{Name: "readAddress", Value: word(0)},
+			Field{Name: "readQuantity", Value: word(2)},
+			Field{Name: "writeAddress", Value: word(4)},
+			Field{Name: "values", Value: modbusRegisters(data[9 : 9+int(data[8])])}), nil
+	}
+
+	if len(data) > 0 {
+		fields = append(fields, Field{Name: "data", Value: hex.EncodeToString(data)})
+	}
+	return fields, nil
+}
+
+// modbusBits returns coil or input states as 0s and 1s, first coil first.
+func modbusBits(data []byte) string {
+	var b strings.Builder
+	for _, v := range data {
+		for i := range 8 {
+			b.WriteByte('0' + (v>>i)&1)
+		}
+	}
+	return b.String()
+}
+
+func modbusRegisters(data []byte) []uint16 {
+	values := make([]uint16, len(data)/2)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	return values
+}
+
+// Describe decodes the header and the data of common function codes.
+func (m ModbusMessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	return describeModbus(m, frame, request)
+}
+
+// Describe decodes the header and the data of common function codes.
+func (m ModbusRTUMessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	return describeModbus(m, frame, request)
+}
+
+// Describe decodes the header and the data of common function codes.
+func (m ModbusSerialMessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	return describeModbus(m, frame, request)
+}
+
+// Describe decodes the header and the data of common function codes.
+func (m ModbusSilenceMessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	return describeModbus(m, frame, request)
+}
+
+// Describe decodes the header and the data of common function codes.
+func (m ModbusASCIIMessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	return describeModbus(m, frame, request)
+}
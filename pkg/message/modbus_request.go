@@ -0,0 +1,197 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ModbusFrame is a Modbus frame independent of its transport.
+type ModbusFrame struct {
+	// TransactionID is only sent by the Modbus TCP transports.
+	TransactionID uint16
+	Unit          byte
+	PDU           []byte
+}
+
+// DecodeModbus strips the transport specific framing of r from frame.
+func DecodeModbus(r Reader, frame []byte) (ModbusFrame, error) {
+	f, ok := r.(modbusFramer)
+	if !ok {
+		return ModbusFrame{}, fmt.Errorf("%s is not a Modbus protocol", r.Name())
+	}
+	adu, err := f.decodeADU(frame)
+	if err != nil {
+		return ModbusFrame{}, err
+	}
+	return ModbusFrame{TransactionID: adu.transactionID, Unit: adu.unitID, PDU: adu.pdu}, nil
+}
+
+// EncodeModbus adds the transport specific framing of r to m.
+func EncodeModbus(r Reader, m ModbusFrame) ([]byte, error) {
+	f, ok := r.(modbusFramer)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a Modbus protocol", r.Name())
+	}
+	return f.encodeADU(modbusADU{transactionID: m.TransactionID, unitID: m.Unit, pdu: m.PDU}), nil
+}
+
+// modbusCommands are the shorthands understood by ParseModbusRequest.
+var modbusCommands = map[string]byte{
+	"read-coils":      modbusFuncReadCoils,
+	"read-discrete":   modbusFuncReadDiscreteInputs,
+	"read-holding":    modbusFuncReadHoldingRegisters,
+	"read-input":      modbusFuncReadInputRegisters,
+	"write-coil":      modbusFuncWriteSingleCoil,
+	"write-register":  modbusFuncWriteSingleRegister,
+	"write-coils":     modbusFuncWriteMultipleCoils,
+	"write-registers": modbusFuncWriteMultipleRegisters,
+}
+
+// ParseModbusRequest parses a request written as a command, the unit ID, the
+// address and the quantity or values, e.g. "read-holding 1 40001 10" or
+// "write-registers 1 100 7 8 9". Decimal addresses of 5 or 6 digits in the
+// Modicon notation of their table, e.g. 00001 for the first coil or 40001 for
+// the first holding register, are converted to zero-based addresses; others
+// are taken as they are.
+func ParseModbusRequest(s string) (ModbusFrame, error) {
+	args := strings.Fields(s)
+	if len(args) == 0 {
+		return ModbusFrame{}, errors.New("empty request")
+	}
+	code, ok := modbusCommands[args[0]]
+	if !ok {
+		return ModbusFrame{}, fmt.Errorf("unknown command %q", args[0])
+	}
+	if len(args) < 4 {
+		return ModbusFrame{}, fmt.Errorf("usage: %s UNIT ADDRESS %s", args[0], modbusCommandOperands(code))
+	}
+
+	unit, err := strconv.ParseUint(args[1], 0, 8)
+	if err != nil {
+		return ModbusFrame{}, fmt.Errorf("invalid unit ID %q", args[1])
+	}
+	address, err := modbusAddress(code, args[2])
+	if err != nil {
+		return ModbusFrame{}, err
+	}
+	values := make([]uint16, 0, len(args)-3)
+	for _, arg := range args[3:] {
+		v, err := modbusValue(code, arg)
+		if err != nil {
+			return ModbusFrame{}, err
+		}
+		values = append(values, v)
+	}
+
+	pdu := binary.BigEndian.AppendUint16([]byte{code}, address)
+	switch code {
+	case modbusFuncReadCoils, modbusFuncReadDiscreteInputs, modbusFuncReadHoldingRegisters, modbusFuncReadInputRegisters,
+		modbusFuncWriteSingleCoil, modbusFuncWriteSingleRegister:
+		if len(values) != 1 {
+			return ModbusFrame{}, fmt.Errorf("usage: %s UNIT ADDRESS %s", args[0], modbusCommandOperands(code))
+		}
+		pdu = binary.BigEndian.AppendUint16(pdu, values[0])
+	case modbusFuncWriteMultipleCoils:
+		pdu = binary.BigEndian.AppendUint16(pdu, uint16(len(values)))
+		bits := make([]byte, (len(values)+7)/8)
+		for i, v := range values {
+			if v != 0 {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		pdu = append(append(pdu, byte(len(bits))), bits...)
+	case modbusFuncWriteMultipleRegisters:
+		pdu = binary.BigEndian.AppendUint16(pdu, uint16(len(values)))
+		pdu = append(pdu, byte(2*len(values)))
+		for _, v := range values {
+			pdu = binary.BigEndian.AppendUint16(pdu, v)
+		}
+	}
+	return ModbusFrame{Unit: byte(unit), PDU: pdu}, nil
+}
+
+func modbusCommandOperands(code byte) string {
+	switch code {
+	case modbusFuncWriteSingleCoil:
+		return "on|off"
+	case modbusFuncWriteSingleRegister:
+		return "VALUE"
+	case modbusFuncWriteMultipleCoils:
+		return "on|off..."
+	case modbusFuncWriteMultipleRegisters:
+		return "VALUE..."
+	}
+	return "QUANTITY"
+}
+
+// modbusTables are the leading digits of the Modicon addresses of the tables
+// read or written by a function.
+var modbusTables = map[byte]byte{
+	modbusFuncReadCoils:              '0',
+	modbusFuncWriteSingleCoil:        '0',
+	modbusFuncWriteMultipleCoils:     '0',
+	modbusFuncReadDiscreteInputs:     '1',
+	modbusFuncReadInputRegisters:     '3',
+	modbusFuncReadHoldingRegisters:   '4',
+	modbusFuncWriteSingleRegister:    '4',
+	modbusFuncWriteMultipleRegisters: '4',
+}
+
+// modbusAddress converts decimal addresses of 5 or 6 digits that start with
+// the digit of the function's table, e.g. 40001 or 400001 for the first
+// holding register, from Modicon notation. Other addresses, including hex
+// ones, are zero-based.
+func modbusAddress(code byte, s string) (uint16, error) {
+	decimal := s != "" && strings.Trim(s, "0123456789") == ""
+	if decimal && (len(s) == 5 || len(s) == 6) && s[0] == modbusTables[code] {
+		a, _ := strconv.ParseUint(s[1:], 10, 32)
+		if a < 1 || a > 0x10000 {
+			return 0, fmt.Errorf("address %s out of range", s)
+		}
+		return uint16(a - 1), nil
+	}
+
+	base := 0
+	if decimal {
+		// leading zeros do not make an octal number
+		base = 10
+	}
+	a, err := strconv.ParseUint(s, base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+	if a > 0xFFFF {
+		return 0, fmt.Errorf("address %s out of range", s)
+	}
+	return uint16(a), nil
+}
+
+func modbusValue(code byte, s string) (uint16, error) {
+	if code == modbusFuncWriteSingleCoil || code == modbusFuncWriteMultipleCoils {
+		on := uint16(1)
+		if code == modbusFuncWriteSingleCoil {
+			on = 0xFF00
+		}
+		switch strings.ToLower(s) {
+		case "on", "1", "true":
+			return on, nil
+		case "off", "0", "false":
+			return 0, nil
+		}
+		return 0, fmt.Errorf("invalid coil state %q", s)
+	}
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return uint16(v), nil
+}
+
+// IsModbus reports whether r reads one of the Modbus transports.
+func IsModbus(r Reader) bool {
+	_, ok := r.(modbusFramer)
+	return ok
+}
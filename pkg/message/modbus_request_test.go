@@ -0,0 +1,89 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseModbusRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  string
+		wantUnit byte
+		wantPDU  []byte
+		wantErr  bool
+	}{
+		{"holding registers in Modicon notation", "read-holding 1 40001 10", 1, []byte{0x03, 0x00, 0x00, 0x00, 0x0A}, false},
+		{"holding registers in 6 digit notation", "read-holding 1 400101 2", 1, []byte{0x03, 0x00, 0x64, 0x00, 0x02}, false},
+		{"zero-based address", "read-input 2 0x10 1", 2, []byte{0x04, 0x00, 0x10, 0x00, 0x01}, false},
+		{"input registers in Modicon notation", "read-input 2 30002 1", 2, []byte{0x04, 0x00, 0x01, 0x00, 0x01}, false},
+		{"coils in Modicon notation", "read-coils 1 00001 8", 1, []byte{0x01, 0x00, 0x00, 0x00, 0x08}, false},
+		{"coil in 6 digit notation", "write-coil 1 000017 off", 1, []byte{0x05, 0x00, 0x10, 0x00, 0x00}, false},
+		{"discrete inputs in Modicon notation", "read-discrete 1 10003 2", 1, []byte{0x02, 0x00, 0x02, 0x00, 0x02}, false},
+		{"prefix of another table", "read-coils 1 40001 8", 1, []byte{0x01, 0x9C, 0x41, 0x00, 0x08}, false},
+		{"zero-based address with the prefix of another table", "read-holding 1 30001 1", 1, []byte{0x03, 0x75, 0x31, 0x00, 0x01}, false},
+		{"hex address is not converted", "read-holding 1 0x9C41 1", 1, []byte{0x03, 0x9C, 0x41, 0x00, 0x01}, false},
+		{"decimal address with leading zeros", "read-holding 1 0010 1", 1, []byte{0x03, 0x00, 0x0A, 0x00, 0x01}, false},
+		{"last holding register in 6 digit notation", "read-holding 1 465536 1", 1, []byte{0x03, 0xFF, 0xFF, 0x00, 0x01}, false},
+		{"single coil", "write-coil 1 5 on", 1, []byte{0x05, 0x00, 0x05, 0xFF, 0x00}, false},
+		{"single register", "write-register 1 40011 0xABCD", 1, []byte{0x06, 0x00, 0x0A, 0xAB, 0xCD}, false},
+		{"multiple coils", "write-coils 1 0 1 0 1 1 0 0 0 0 1", 1, []byte{0x0F, 0x00, 0x00, 0x00, 0x09, 0x02, 0x0D, 0x01}, false},
+		{"multiple registers", "write-registers 3 100 7 8", 3, []byte{0x10, 0x00, 0x64, 0x00, 0x02, 0x04, 0x00, 0x07, 0x00, 0x08}, false},
+		{"unknown command", "read-everything 1 0 1", 0, nil, true},
+		{"missing quantity", "read-holding 1 40001", 0, nil, true},
+		{"extra quantity", "read-holding 1 40001 1 2", 0, nil, true},
+		{"invalid unit", "read-holding 256 0 1", 0, nil, true},
+		{"invalid coil state", "write-coil 1 0 maybe", 0, nil, true},
+		{"address out of range", "read-coils 1 70000 1", 0, nil, true},
+		{"Modicon address 0", "read-holding 1 40000 1", 0, nil, true},
+		{"Modicon address out of range", "read-holding 1 465537 1", 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseModbusRequest(tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseModbusRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Unit != tt.wantUnit || !bytes.Equal(got.PDU, tt.wantPDU) {
+				t.Errorf("ParseModbusRequest() got = unit %d, PDU %x, want unit %d, PDU %x", got.Unit, got.PDU, tt.wantUnit, tt.wantPDU)
+			}
+		})
+	}
+}
+
+func TestEncodeModbus(t *testing.T) {
+	m := ModbusFrame{TransactionID: 0x1234, Unit: 1, PDU: []byte{0x03, 0x00, 0x00, 0x00, 0x01}}
+	tests := []struct {
+		reader Reader
+		want   []byte
+	}{
+		{&ModbusMessageReader{}, []byte{0x12, 0x34, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}},
+		{&ModbusSerialMessageReader{}, []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}},
+		{&ModbusASCIIMessageReader{}, []byte(":010300000001FB\r\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reader.Name(), func(t *testing.T) {
+			frame, err := EncodeModbus(tt.reader, m)
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if !bytes.Equal(frame, tt.want) {
+				t.Fatalf("EncodeModbus() got = %x, want %x", frame, tt.want)
+			}
+			got, err := DecodeModbus(tt.reader, frame)
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if got.Unit != m.Unit || !bytes.Equal(got.PDU, m.PDU) {
+				t.Errorf("DecodeModbus() got = %+v, want %+v", got, m)
+			}
+		})
+	}
+
+	if _, err := EncodeModbus(&HTTPMessageReader{}, m); err == nil {
+		t.Error("Expected an error encoding Modbus for http")
+	}
+}
@@ -3,7 +3,9 @@ package message
 import (
 	"bytes"
 	"encoding/binary"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestModbusSerialMessageReader_ReadMessage(t *testing.T) {
@@ -90,3 +92,170 @@ func TestModbusSerialMessageReader_ReadMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestModbusSilenceMessageReader_ReadMessage(t *testing.T) {
+	reader := ModbusSilenceMessageReader{Silence: 20 * time.Millisecond}
+
+	tests := []struct {
+		name    string
+		chunks  [][]byte
+		wantErr bool
+	}{
+		{
+			name: "FC43 Read Device Identification Request",
+			// Addr(1), Func(0x2B), MEI(0x0E), ReadDevId(1), ObjectId(0)
+			chunks: [][]byte{encodeRTU(0x01, []byte{0x2B, 0x0E, 0x01, 0x00})},
+		},
+		{
+			name: "FC08 Diagnostics Split Across Writes",
+			chunks: func() [][]byte {
+				f := encodeRTU(0x01, []byte{0x08, 0x00, 0x00, 0xA5, 0x37})
+				return [][]byte{f[:3], f[3:]}
+			}(),
+		},
+		{
+			name: "CRC Error",
+			chunks: func() [][]byte {
+				f := encodeRTU(0x01, []byte{0x07})
+				f[len(f)-1] ^= 0xFF
+				return [][]byte{f}
+			}(),
+			wantErr: true,
+		},
+		{
+			name:    "Too Short",
+			chunks:  [][]byte{{0x01, 0x07}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer func() { _ = client.Close() }()
+			defer func() { _ = server.Close() }()
+
+			go func() {
+				for _, chunk := range tt.chunks {
+					_, _ = client.Write(chunk)
+					time.Sleep(2 * time.Millisecond)
+				}
+			}()
+
+			got, err := reader.ReadMessage(server)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadMessage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			want := bytes.Join(tt.chunks, nil)
+			if err == nil && !bytes.Equal(got, want) {
+				t.Errorf("ReadMessage() got = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestModbusSilenceMessageReader_RequiresDeadline(t *testing.T) {
+	_, err := ModbusSilenceMessageReader{Silence: time.Millisecond}.ReadMessage(bytes.NewReader(encodeRTU(0x01, []byte{0x07})))
+	if err == nil {
+		t.Fatal("Expected an error for a connection without read deadlines")
+	}
+}
+
+func TestModbusInterFrameDelay(t *testing.T) {
+	if got := ModbusInterFrameDelay(9600); got < 4*time.Millisecond || got > 4100*time.Microsecond {
+		t.Errorf("ModbusInterFrameDelay(9600) = %v, want ~4.01ms", got)
+	}
+	if got := ModbusInterFrameDelay(115200); got != 1750*time.Microsecond {
+		t.Errorf("ModbusInterFrameDelay(115200) = %v, want 1.75ms", got)
+	}
+}
+
+func TestModbusSerialMessageReader_ReadRequestResponse(t *testing.T) {
+	reader := &ModbusSerialMessageReader{}
+
+	tests := []struct {
+		name     string
+		response bool
+		pdu      []byte
+		wantErr  bool
+	}{
+		{name: "FC01 Request", pdu: []byte{0x01, 0x00, 0x13, 0x00, 0x13}},
+		{name: "FC01 Response", response: true, pdu: []byte{0x01, 0x03, 0xCD, 0x6B, 0x05}},
+		{name: "FC03 Request", pdu: []byte{0x03, 0x00, 0x6B, 0x00, 0x03}},
+		{name: "FC03 Response", response: true, pdu: []byte{0x03, 0x06, 0x02, 0x2B, 0x00, 0x00, 0x00, 0x64}},
+		{name: "FC05 Request", pdu: []byte{0x05, 0x00, 0xAC, 0xFF, 0x00}},
+		{name: "FC05 Response", response: true, pdu: []byte{0x05, 0x00, 0xAC, 0xFF, 0x00}},
+		{name: "FC06 Request", pdu: []byte{0x06, 0x00, 0x01, 0x00, 0x03}},
+		{name: "FC06 Response", response: true, pdu: []byte{0x06, 0x00, 0x01, 0x00, 0x03}},
+		{name: "FC07 Request", pdu: []byte{0x07}},
+		{name: "FC07 Response", response: true, pdu: []byte{0x07, 0x6D}},
+		{name: "FC08 Request", pdu: []byte{0x08, 0x00, 0x00, 0xA5, 0x37}},
+		{name: "FC08 Response", response: true, pdu: []byte{0x08, 0x00, 0x00, 0xA5, 0x37}},
+		{name: "FC11 Request", pdu: []byte{0x0B}},
+		{name: "FC11 Response", response: true, pdu: []byte{0x0B, 0xFF, 0xFF, 0x01, 0x08}},
+		{name: "FC12 Request", pdu: []byte{0x0C}},
+		{name: "FC12 Response", response: true, pdu: []byte{0x0C, 0x08, 0x00, 0x00, 0x01, 0x08, 0x01, 0x21, 0x20, 0x00}},
+		{name: "FC15 Request", pdu: []byte{0x0F, 0x00, 0x13, 0x00, 0x0A, 0x02, 0xCD, 0x01}},
+		{name: "FC15 Response", response: true, pdu: []byte{0x0F, 0x00, 0x13, 0x00, 0x0A}},
+		{name: "FC16 Request", pdu: []byte{0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x0A, 0x01, 0x02}},
+		{name: "FC16 Response", response: true, pdu: []byte{0x10, 0x00, 0x01, 0x00, 0x02}},
+		{name: "FC17 Request", pdu: []byte{0x11}},
+		{name: "FC17 Response", response: true, pdu: []byte{0x11, 0x03, 0x42, 0xFF, 0x00}},
+		{name: "FC20 Request", pdu: []byte{0x14, 0x0E, 0x06, 0x00, 0x04, 0x00, 0x01, 0x00, 0x02, 0x06, 0x00, 0x03, 0x00, 0x09, 0x00, 0x02}},
+		{name: "FC20 Response", response: true, pdu: []byte{0x14, 0x0C, 0x05, 0x06, 0x0D, 0xFE, 0x00, 0x20, 0x05, 0x06, 0x33, 0xCD, 0x00, 0x40}},
+		{name: "FC21 Request", pdu: []byte{0x15, 0x0D, 0x06, 0x00, 0x04, 0x00, 0x07, 0x00, 0x03, 0x06, 0xAF, 0x04, 0xBE, 0x10, 0x0D}},
+		{name: "FC21 Response", response: true, pdu: []byte{0x15, 0x0D, 0x06, 0x00, 0x04, 0x00, 0x07, 0x00, 0x03, 0x06, 0xAF, 0x04, 0xBE, 0x10, 0x0D}},
+		{name: "FC22 Request", pdu: []byte{0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}},
+		{name: "FC22 Response", response: true, pdu: []byte{0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}},
+		{name: "FC23 Request", pdu: []byte{0x17, 0x00, 0x03, 0x00, 0x06, 0x00, 0x0E, 0x00, 0x03, 0x06, 0x00, 0xFF, 0x00, 0xFF, 0x00, 0xFF}},
+		{name: "FC23 Response", response: true, pdu: []byte{0x17, 0x0C, 0x00, 0xFE, 0x0A, 0xCD, 0x00, 0x01, 0x00, 0x03, 0x00, 0x0D, 0x00, 0xFF}},
+		{name: "FC24 Request", pdu: []byte{0x18, 0x04, 0xDE}},
+		{name: "FC24 Response", response: true, pdu: []byte{0x18, 0x00, 0x06, 0x00, 0x02, 0x01, 0xB8, 0x12, 0x84}},
+		{name: "FC43/14 Request", pdu: []byte{0x2B, 0x0E, 0x01, 0x00}},
+		{name: "FC43/14 Response", response: true, pdu: []byte{
+			0x2B, 0x0E, 0x01, 0x01, 0x00, 0x00, 0x03,
+			0x00, 0x03, 'A', 'C', 'M',
+			0x01, 0x02, 'P', '1',
+			0x02, 0x04, 'V', '1', '.', '0',
+		}},
+		{name: "Exception Response", response: true, pdu: []byte{0x83, 0x02}},
+		{name: "FC43/13 Request Unsupported", pdu: []byte{0x2B, 0x0D, 0x00, 0x00}, wantErr: true},
+		{name: "Unknown Function Code Request", pdu: []byte{0x41, 0x00}, wantErr: true},
+		{name: "Unknown Function Code Response", response: true, pdu: []byte{0x41, 0x00}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := encodeRTU(0x11, tt.pdu)
+			// the reader must not consume bytes beyond the frame
+			conn := bytes.NewBuffer(append(bytes.Clone(frame), 0xAA, 0xBB))
+
+			read := reader.ReadRequest
+			if tt.response {
+				read = reader.ReadResponse
+			}
+			got, err := read(conn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				if !bytes.Equal(got, frame) {
+					t.Errorf("got = %x, want %x", got, frame)
+				}
+				if conn.Len() != 2 {
+					t.Errorf("%d bytes left unread, want 2", conn.Len())
+				}
+			}
+		})
+	}
+
+	t.Run("CRC Error", func(t *testing.T) {
+		frame := encodeRTU(0x11, []byte{0x07})
+		frame[len(frame)-1] ^= 0xFF
+		if _, err := reader.ReadRequest(bytes.NewBuffer(frame)); err == nil {
+			t.Error("Expected CRC error")
+		}
+	})
+}
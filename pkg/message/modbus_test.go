@@ -3,6 +3,8 @@ package message
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -12,6 +14,8 @@ func TestModbusMessageReader_ReadMessage(t *testing.T) {
 		reader  Reader
 		payload []byte
 		wantErr bool
+		// errIs is the sentinel the error wraps
+		errIs error
 	}{
 		{
 			name:   "Modbus TCP Normal",
@@ -51,6 +55,21 @@ func TestModbusMessageReader_ReadMessage(t *testing.T) {
 				0xFF, 0xFF, // Invalid CRC
 			},
 			wantErr: true,
+			errIs:   ErrProtocol,
+		},
+		{
+			name:    "Modbus TCP Zero Length",
+			reader:  &ModbusMessageReader{},
+			payload: []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x00},
+			wantErr: true,
+			errIs:   ErrMBAPHeader,
+		},
+		{
+			name:    "Modbus TCP Length Too Large",
+			reader:  &ModbusMessageReader{},
+			payload: []byte{0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x03},
+			wantErr: true,
+			errIs:   ErrMBAPHeader,
 		},
 		{
 			name:   "Modbus TCP Max Length (260)",
@@ -90,6 +109,9 @@ func TestModbusMessageReader_ReadMessage(t *testing.T) {
 				t.Errorf("%s: ReadMessage() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 				return
 			}
+			if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+				t.Errorf("%s: ReadMessage() error = %v, want %v", tt.name, err, tt.errIs)
+			}
 			if err == nil {
 				if len(got) != len(tt.payload) {
 					t.Errorf("%s: ReadMessage() got length = %v, want %v", tt.name, len(got), len(tt.payload))
@@ -98,3 +120,263 @@ func TestModbusMessageReader_ReadMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestModbusBroadcast(t *testing.T) {
+	tests := []struct {
+		name      string
+		reader    Broadcaster
+		req       []byte
+		broadcast bool
+	}{
+		{
+			name:      "RTU Write Single Register To Unit 0",
+			reader:    &ModbusSerialMessageReader{},
+			req:       encodeRTU(0x00, []byte{0x06, 0x00, 0x01, 0x00, 0x03}),
+			broadcast: true,
+		},
+		{
+			name:   "RTU Write Single Register To Unit 1",
+			reader: &ModbusSerialMessageReader{},
+			req:    encodeRTU(0x01, []byte{0x06, 0x00, 0x01, 0x00, 0x03}),
+		},
+		{
+			name:   "RTU Read To Unit 0",
+			reader: &ModbusSerialMessageReader{},
+			req:    encodeRTU(0x00, []byte{0x03, 0x00, 0x01, 0x00, 0x03}),
+		},
+		{
+			name:      "RTU over TCP Write Multiple Registers To Unit 0",
+			reader:    &ModbusRTUMessageReader{},
+			req:       ModbusRTUMessageReader{}.encodeADU(modbusADU{transactionID: 1, pdu: []byte{0x10, 0x00, 0x01, 0x00, 0x01, 0x02, 0x00, 0x0A}}),
+			broadcast: true,
+		},
+		{
+			name:      "ASCII Write Single Coil To Unit 0",
+			reader:    &ModbusASCIIMessageReader{},
+			req:       ModbusASCIIMessageReader{}.encodeADU(modbusADU{pdu: []byte{0x05, 0x00, 0xAC, 0xFF, 0x00}}),
+			broadcast: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.reader.IsBroadcast(tt.req); got != tt.broadcast {
+				t.Errorf("IsBroadcast() = %v, want %v", got, tt.broadcast)
+			}
+		})
+	}
+}
+
+func TestModbusMessageReader_Acknowledge(t *testing.T) {
+	tests := []struct {
+		name string
+		req  []byte
+		want []byte
+	}{
+		{
+			name: "Write Single Register",
+			req:  []byte{0x00, 0x05, 0x00, 0x00, 0x00, 0x06, 0x00, 0x06, 0x00, 0x01, 0x00, 0x03},
+			want: []byte{0x00, 0x05, 0x00, 0x00, 0x00, 0x06, 0x00, 0x06, 0x00, 0x01, 0x00, 0x03},
+		},
+		{
+			name: "Write Multiple Registers",
+			req:  []byte{0x00, 0x05, 0x00, 0x00, 0x00, 0x09, 0x00, 0x10, 0x00, 0x01, 0x00, 0x01, 0x02, 0x00, 0x0A},
+			want: []byte{0x00, 0x05, 0x00, 0x00, 0x00, 0x06, 0x00, 0x10, 0x00, 0x01, 0x00, 0x01},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ModbusMessageReader{}.Acknowledge(tt.req)
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Acknowledge() got = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModbusBusy(t *testing.T) {
+	tests := []struct {
+		name   string
+		reader BusyResponder
+		req    []byte
+		want   []byte
+	}{
+		{
+			name:   "modbus",
+			reader: ModbusMessageReader{},
+			req:    []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01},
+			want:   []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, 0x06},
+		},
+		{
+			name:   "modbus-serial",
+			reader: ModbusSerialMessageReader{},
+			req:    encodeRTU(0x01, []byte{0x03, 0x00, 0x00, 0x00, 0x01}),
+			want:   encodeRTU(0x01, []byte{0x83, 0x06}),
+		},
+		{
+			name:   "modbus-ascii",
+			reader: ModbusASCIIMessageReader{},
+			req:    []byte(":1103006B00037E\r\n"),
+			want:   []byte(":11830666\r\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.reader.Busy(tt.req)
+			if err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Busy() got = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModbusMatches(t *testing.T) {
+	req := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	tests := []struct {
+		name string
+		resp []byte
+		want bool
+	}{
+		{
+			name: "response",
+			resp: []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2A},
+			want: true,
+		},
+		{
+			name: "other transaction",
+			resp: []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2A},
+			want: false,
+		},
+		{
+			name: "other unit",
+			resp: []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x05, 0x02, 0x03, 0x02, 0x00, 0x2A},
+			want: false,
+		},
+		{
+			name: "too short",
+			resp: []byte{0x00, 0x07},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (ModbusMessageReader{}).Matches(req, tt.resp); got != tt.want {
+				t.Errorf("Matches() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModbusDescribe(t *testing.T) {
+	tests := []struct {
+		name    string
+		reader  Describer
+		frame   []byte
+		request bool
+		want    []Field
+		wantErr bool
+	}{
+		{
+			name:    "read holding registers",
+			reader:  ModbusMessageReader{},
+			frame:   []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x10, 0x00, 0x02},
+			request: true,
+			want: []Field{
+				{Name: "transaction", Value: uint16(7)},
+				{Name: "unit", Value: byte(1)},
+				{Name: "function", Value: "read holding registers (3)"},
+				{Name: "address", Value: uint16(16)},
+				{Name: "quantity", Value: uint16(2)},
+			},
+		},
+		{
+			name:   "registers",
+			reader: ModbusSerialMessageReader{},
+			frame:  encodeRTU(0x11, []byte{0x03, 0x04, 0x00, 0x2A, 0x01, 0x00}),
+			want: []Field{
+				{Name: "unit", Value: byte(0x11)},
+				{Name: "function", Value: "read holding registers (3)"},
+				{Name: "values", Value: []uint16{42, 256}},
+			},
+		},
+		{
+			name:   "coils",
+			reader: ModbusSerialMessageReader{},
+			frame:  encodeRTU(0x01, []byte{0x01, 0x01, 0x05}),
+			want: []Field{
+				{Name: "unit", Value: byte(1)},
+				{Name: "function", Value: "read coils (1)"},
+				{Name: "bits", Value: "10100000"},
+			},
+		},
+		{
+			name:    "write multiple registers",
+			reader:  ModbusRTUMessageReader{},
+			frame:   ModbusRTUMessageReader{}.encodeADU(modbusADU{transactionID: 2, unitID: 1, pdu: []byte{0x10, 0x00, 0x01, 0x00, 0x01, 0x02, 0x00, 0x0A}}),
+			request: true,
+			want: []Field{
+				{Name: "transaction", Value: uint16(2)},
+				{Name: "unit", Value: byte(1)},
+				{Name: "function", Value: "write multiple registers (16)"},
+				{Name: "address", Value: uint16(1)},
+				{Name: "quantity", Value: uint16(1)},
+				{Name: "values", Value: []uint16{10}},
+			},
+		},
+		{
+			name:   "exception",
+			reader: ModbusASCIIMessageReader{},
+			frame:  []byte(":11830666\r\n"),
+			want: []Field{
+				{Name: "unit", Value: byte(0x11)},
+				{Name: "function", Value: "read holding registers (3)"},
+				{Name: "exception", Value: "server device busy (6)"},
+			},
+		},
+		{
+			name:   "other function",
+			reader: ModbusSerialMessageReader{},
+			frame:  encodeRTU(0x01, []byte{0x41, 0xAB}),
+			want: []Field{
+				{Name: "unit", Value: byte(1)},
+				{Name: "function", Value: "65"},
+				{Name: "data", Value: "ab"},
+			},
+		},
+		{
+			name:    "truncated",
+			reader:  ModbusSerialMessageReader{},
+			frame:   encodeRTU(0x01, []byte{0x03, 0x00}),
+			request: true,
+			wantErr: true,
+		},
+		{
+			name:    "bad CRC",
+			reader:  ModbusSerialMessageReader{},
+			frame:   []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00},
+			request: true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.reader.Describe(tt.frame, tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Describe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Describe() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,20 @@
 package message
 
 import (
+	"fmt"
 	"io"
 	"strconv"
+	"time"
 )
 
 // MPUMessageReader for reading MPU Switch format iso8583.
 type MPUMessageReader struct {
+	// Spec is the message layout, DefaultISO8583Spec if nil.
+	Spec *ISO8583Spec
+	// Network configures sign-on and echo tests, none if nil.
+	Network *ISO8583Network
+	// Session marks requests that hold the session lease, none if nil.
+	Session *ISO8583Session
 }
 
 func (M MPUMessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
@@ -35,3 +43,85 @@ func (M MPUMessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
 func (M MPUMessageReader) Name() string {
 	return "mpu"
 }
+
+func (M MPUMessageReader) spec() *ISO8583Spec {
+	return M.Spec
+}
+
+func (M MPUMessageReader) prefixLength() int {
+	return 4
+}
+
+// Decode decodes a message including its length prefix.
+func (M MPUMessageReader) Decode(frame []byte) (*ISO8583Message, error) {
+	return decodeISO8583(M, frame)
+}
+
+// Matches reports whether resp is the response to req, see
+// ISO8583Message.Answers.
+func (M MPUMessageReader) Matches(req, resp []byte) bool {
+	return iso8583Matches(M, req, resp)
+}
+
+func (M MPUMessageReader) network() *ISO8583Network {
+	return M.Network
+}
+
+func (M MPUMessageReader) frame(msg []byte) ([]byte, error) {
+	if len(msg) > 9999 {
+		return nil, fmt.Errorf("message too long (%d bytes)", len(msg))
+	}
+	return append(fmt.Appendf(nil, "%04d", len(msg)), msg...), nil
+}
+
+// Encode encodes a message including its length prefix.
+func (M MPUMessageReader) Encode(m *ISO8583Message) ([]byte, error) {
+	return encodeISO8583(M, m)
+}
+
+// SignOn returns the configured sign-on request.
+func (M MPUMessageReader) SignOn() ([]byte, error) {
+	if M.Network == nil {
+		return nil, nil
+	}
+	return iso8583NetworkRequest(M, M.Network.SignOn)
+}
+
+// KeepAlive returns an echo test request.
+func (M MPUMessageReader) KeepAlive() ([]byte, error) {
+	if M.Network == nil {
+		return nil, nil
+	}
+	return iso8583NetworkRequest(M, M.Network.EchoTest)
+}
+
+// KeepAliveInterval returns the configured echo test interval.
+func (M MPUMessageReader) KeepAliveInterval() time.Duration {
+	return iso8583KeepAliveInterval(M)
+}
+
+// Accepted returns an error unless resp approves req with response code 00.
+func (M MPUMessageReader) Accepted(req, resp []byte) error {
+	return iso8583Accepted(M, req, resp)
+}
+
+// Answer approves network management requests (x8x0) of the host.
+func (M MPUMessageReader) Answer(frame []byte) ([]byte, error) {
+	return iso8583Answer(M, frame)
+}
+
+// Mask masks the PAN and removes track data, the PIN block and other fields
+// the spec marks for redaction.
+func (M MPUMessageReader) Mask(frame []byte) []byte {
+	return iso8583Mask(M, frame)
+}
+
+// Describe returns the MTI and key fields without card data.
+func (M MPUMessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	return describeISO8583(M, frame)
+}
+
+// HoldSession reports whether req is marked to hold the session by Session.
+func (M MPUMessageReader) HoldSession(req []byte) bool {
+	return iso8583HoldSession(M, M.Session, req)
+}
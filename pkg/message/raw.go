@@ -0,0 +1,38 @@
+package message
+
+import (
+	"io"
+	"time"
+)
+
+// DefaultRawIdleGap is how long a raw session lasts without bytes flowing in
+// either direction unless configured otherwise.
+const DefaultRawIdleGap = 100 * time.Millisecond
+
+// RawMessageReader passes bytes through without framing them. Each client
+// gets the target connection for itself until no bytes have been sent in
+// either direction for Gap.
+type RawMessageReader struct {
+	Gap time.Duration
+}
+
+func (r RawMessageReader) Name() string {
+	return "raw"
+}
+
+// ReadMessage returns the bytes available from a single read.
+func (r RawMessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if n > 0 {
+		return buf[:n], nil
+	}
+	return nil, err
+}
+
+func (r RawMessageReader) IdleGap() time.Duration {
+	if r.Gap <= 0 {
+		return DefaultRawIdleGap
+	}
+	return r.Gap
+}
@@ -0,0 +1,74 @@
+package message
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// scpiMaxMessageLength limits the length of a message, which SCPI does not.
+const scpiMaxMessageLength = 64 * 1024
+
+// SCPIMessageReader reads newline terminated SCPI program and response
+// messages, e.g. of instruments on port 5025. Only messages containing a
+// query are answered. Arbitrary block data is not supported.
+type SCPIMessageReader struct {
+}
+
+func (s SCPIMessageReader) Name() string {
+	return "scpi"
+}
+
+// ReadMessage reads up to and including the terminating newline. It reads
+// byte by byte so that nothing beyond the message is consumed.
+func (s SCPIMessageReader) ReadMessage(conn io.Reader) ([]byte, error) {
+	var msg []byte
+	b := make([]byte, 1)
+	for len(msg) < scpiMaxMessageLength {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			if err == io.ErrUnexpectedEOF || (err == io.EOF && len(msg) > 0) {
+				return nil, fmt.Errorf("%w: incomplete message: %w", ErrProtocol, io.ErrUnexpectedEOF)
+			}
+			return nil, err
+		}
+		msg = append(msg, b[0])
+		if b[0] == '\n' {
+			return msg, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: message exceeds %d bytes", ErrProtocol, scpiMaxMessageLength)
+}
+
+// Responses returns OneResponse for messages containing a query, and
+// NoResponse for messages that only contain commands.
+func (s SCPIMessageReader) Responses(req []byte) Responses {
+	quote := byte(0)
+	for _, b := range req {
+		switch {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '?':
+			return OneResponse
+		}
+	}
+	return NoResponse
+}
+
+// IsFinal returns true, since queries are answered by a single message.
+func (s SCPIMessageReader) IsFinal(req, resp []byte) bool {
+	return true
+}
+
+// Describe returns the text of a message and, for requests, whether it is
+// answered.
+func (s SCPIMessageReader) Describe(frame []byte, request bool) ([]Field, error) {
+	fields := []Field{{Name: "text", Value: strings.TrimRight(string(frame), "\r\n")}}
+	if request {
+		fields = append(fields, Field{Name: "query", Value: s.Responses(frame) == OneResponse})
+	}
+	return fields, nil
+}
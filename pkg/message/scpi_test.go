@@ -0,0 +1,56 @@
+package message
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSCPIMessageReader_ReadMessage(t *testing.T) {
+	conn := bytes.NewReader([]byte("*IDN?\nVOLT 5;:OUTP ON\nMEAS"))
+
+	for _, want := range []string{"*IDN?\n", "VOLT 5;:OUTP ON\n"} {
+		got, err := SCPIMessageReader{}.ReadMessage(conn)
+		if err != nil {
+			t.Fatal("Expected no error, but got:", err)
+		}
+		if string(got) != want {
+			t.Fatalf("ReadMessage() got = %q, want %q", got, want)
+		}
+	}
+	if _, err := (SCPIMessageReader{}).ReadMessage(conn); err == nil {
+		t.Fatal("Expected an error for an incomplete message")
+	}
+}
+
+func TestSCPIMessageReader_Responses(t *testing.T) {
+	tests := []struct {
+		req  string
+		want Responses
+	}{
+		{req: "*IDN?\n", want: OneResponse},
+		{req: "VOLT 5\n", want: NoResponse},
+		{req: "VOLT 5;:MEAS:VOLT?\n", want: OneResponse},
+		{req: "DISP:TEXT \"Ready?\"\n", want: NoResponse},
+		{req: "DISP:TEXT 'Ready?';*OPC?\n", want: OneResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.req, func(t *testing.T) {
+			if got := (SCPIMessageReader{}).Responses([]byte(tt.req)); got != tt.want {
+				t.Errorf("Responses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSCPIMessageReader_Describe(t *testing.T) {
+	got, err := SCPIMessageReader{}.Describe([]byte("MEAS:VOLT?\n"), true)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	want := []Field{{Name: "text", Value: "MEAS:VOLT?"}, {Name: "query", Value: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Describe() got = %v, want %v", got, want)
+	}
+}
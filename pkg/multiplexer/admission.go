@@ -0,0 +1,62 @@
+package multiplexer
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+)
+
+// admission decides whether a new client connection is accepted.
+type admission struct {
+	maxClients      int
+	maxClientsPerIP int
+	allow           []*net.IPNet
+	deny            []*net.IPNet
+
+	mu      sync.Mutex
+	clients int
+	perIP   map[string]int
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	return slices.ContainsFunc(networks, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// admit returns why a client connecting from ip is rejected, or nil if it is
+// accepted. Accepted clients must be released once they disconnect.
+func (a *admission) admit(ip net.IP) error {
+	if containsIP(a.deny, ip) {
+		return fmt.Errorf("source address is denied")
+	}
+	if len(a.allow) > 0 && !containsIP(a.allow, ip) {
+		return fmt.Errorf("source address is not allowed")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxClients > 0 && a.clients >= a.maxClients {
+		return fmt.Errorf("maximum of %d clients reached", a.maxClients)
+	}
+	key := ip.String()
+	if a.maxClientsPerIP > 0 && a.perIP[key] >= a.maxClientsPerIP {
+		return fmt.Errorf("maximum of %d clients per source address reached", a.maxClientsPerIP)
+	}
+	if a.perIP == nil {
+		a.perIP = make(map[string]int)
+	}
+	a.clients++
+	a.perIP[key]++
+	return nil
+}
+
+func (a *admission) release(ip net.IP) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clients--
+	key := ip.String()
+	a.perIP[key]--
+	if a.perIP[key] == 0 {
+		delete(a.perIP, key)
+	}
+}
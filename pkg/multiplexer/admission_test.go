@@ -0,0 +1,52 @@
+package multiplexer
+
+import (
+	"net"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAdmission(t *testing.T) {
+	a := &admission{
+		maxClients:      3,
+		maxClientsPerIP: 2,
+		allow:           []*net.IPNet{mustParseCIDR(t, "10.0.0.0/8")},
+		deny:            []*net.IPNet{mustParseCIDR(t, "10.0.0.66/32")},
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{name: "allowed", ip: "10.0.0.1", want: true},
+		{name: "not allowed", ip: "192.168.1.1", want: false},
+		{name: "denied", ip: "10.0.0.66", want: false},
+		{name: "second client from same IP", ip: "10.0.0.1", want: true},
+		{name: "per IP limit", ip: "10.0.0.1", want: false},
+		{name: "other IP", ip: "10.0.0.2", want: true},
+		{name: "total limit", ip: "10.0.0.3", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.admit(net.ParseIP(tt.ip))
+			if (err == nil) != tt.want {
+				t.Errorf("admit() error = %v, want admitted %v", err, tt.want)
+			}
+		})
+	}
+
+	a.release(net.ParseIP("10.0.0.1"))
+	if err := a.admit(net.ParseIP("10.0.0.3")); err != nil {
+		t.Error("Expected a client to be admitted after another one left, but got:", err)
+	}
+}
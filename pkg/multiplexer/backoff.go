@@ -0,0 +1,42 @@
+package multiplexer
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff computes exponentially growing delays with jitter between failed
+// attempts to connect to the target server.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+
+	current time.Duration
+	next    time.Time
+}
+
+// failed records a failed attempt and returns the delay until the next one.
+func (b *backoff) failed() time.Duration {
+	if b.current == 0 {
+		b.current = b.initial
+	} else {
+		b.current = min(2*b.current, max(b.max, b.initial))
+	}
+
+	// equal jitter: wait between half and the full delay so that several
+	// multiplexers do not hammer a recovering target in lockstep
+	d := b.current/2 + rand.N(b.current/2+1)
+	b.next = time.Now().Add(d)
+	return d
+}
+
+// reset is called after a successful attempt.
+func (b *backoff) reset() {
+	b.current = 0
+	b.next = time.Time{}
+}
+
+// waiting reports whether the next attempt has to wait.
+func (b *backoff) waiting() bool {
+	return time.Now().Before(b.next)
+}
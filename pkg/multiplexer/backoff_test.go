@@ -0,0 +1,29 @@
+package multiplexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := backoff{initial: 100 * time.Millisecond, max: 350 * time.Millisecond}
+
+	for _, want := range []time.Duration{100, 200, 350, 350} {
+		want *= time.Millisecond
+		d := b.failed()
+		if d < want/2 || d > want {
+			t.Errorf("failed() = %v, want between %v and %v", d, want/2, want)
+		}
+		if !b.waiting() {
+			t.Error("Expected to be waiting after a failed attempt")
+		}
+	}
+
+	b.reset()
+	if b.waiting() {
+		t.Error("Expected not to be waiting after a reset")
+	}
+	if d := b.failed(); d > 100*time.Millisecond {
+		t.Errorf("failed() after reset = %v, want at most the initial delay", d)
+	}
+}
@@ -0,0 +1,110 @@
+package multiplexer
+
+import (
+	"log/slog"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker guarding the target server.
+type CircuitState int32
+
+const (
+	// CircuitClosed lets all requests through to the target server.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen holds back all requests after repeated failures.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe request through after the retry
+	// delay to test whether the target server has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// circuitBreaker tracks consecutive failures to connect to, write to or read
+// from the target server. It is only used by the target loop, which sends one
+// request at a time, so a half-open circuit lets exactly one probe through.
+type circuitBreaker struct {
+	// threshold is the number of consecutive failures that opens the circuit.
+	threshold int
+	retry     backoff
+	state     CircuitState
+	failures  int
+	lastErr   error
+	// onChange is called on every state transition.
+	onChange func(CircuitState)
+}
+
+// ready reports whether a request may be sent to the target server, moving an
+// open circuit to half-open once the retry delay has passed. Below the
+// threshold, the next attempt also waits for the retry delay so that a
+// closed circuit does not reconnect back-to-back.
+func (c *circuitBreaker) ready() bool {
+	if c.retry.waiting() {
+		return false
+	}
+	if c.state == CircuitOpen {
+		c.setState(CircuitHalfOpen)
+		slog.Info("target circuit half-open, probing target")
+	}
+	return true
+}
+
+// success records a successful exchange with the target server.
+func (c *circuitBreaker) success() {
+	c.failures = 0
+	c.lastErr = nil
+	c.retry.reset()
+	if c.state != CircuitClosed {
+		c.setState(CircuitClosed)
+		slog.Info("target circuit closed")
+	}
+}
+
+// failure records a failed exchange with the target server.
+func (c *circuitBreaker) failure(err error) {
+	c.failures++
+	c.lastErr = err
+	d := c.retry.failed()
+	if c.state == CircuitHalfOpen || c.failures >= c.threshold {
+		c.setState(CircuitOpen)
+		slog.Warn("target circuit open", "failures", c.failures, "retryIn", d, "error", err)
+		return
+	}
+	slog.Info("target failure, retrying", "failures", c.failures, "retryIn", d, "error", err)
+}
+
+// waiting reports whether the next attempt has to wait for the retry delay.
+func (c *circuitBreaker) waiting() bool {
+	return c.retry.waiting()
+}
+
+// err returns the error requests fail with while the circuit is open.
+func (c *circuitBreaker) err() error {
+	return c.lastErr
+}
+
+// nextProbe returns when the next attempt may be made.
+func (c *circuitBreaker) nextProbe() time.Time {
+	return c.retry.next
+}
+
+func (c *circuitBreaker) setState(state CircuitState) {
+	c.state = state
+	if c.onChange != nil {
+		c.onChange(state)
+	}
+}
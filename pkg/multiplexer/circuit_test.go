@@ -0,0 +1,70 @@
+package multiplexer
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var transitions []CircuitState
+	c := circuitBreaker{
+		threshold: 2,
+		retry:     backoff{initial: 20 * time.Millisecond, max: 20 * time.Millisecond},
+		onChange:  func(s CircuitState) { transitions = append(transitions, s) },
+	}
+
+	if !c.ready() {
+		t.Fatal("Expected a closed circuit to be ready")
+	}
+
+	c.failure(errors.New("timeout"))
+	if c.state != CircuitClosed {
+		t.Fatal("Expected the circuit to stay closed below the threshold")
+	}
+	if c.ready() {
+		t.Fatal("Expected a failure below the threshold to delay the next attempt")
+	}
+	time.Sleep(25 * time.Millisecond)
+	if !c.ready() || c.state != CircuitClosed {
+		t.Fatal("Expected the circuit to be ready and closed after the retry delay")
+	}
+
+	c.failure(errors.New("timeout"))
+	if c.state != CircuitOpen || c.ready() {
+		t.Fatal("Expected the circuit to open at the threshold")
+	}
+	if c.err() == nil {
+		t.Fatal("Expected the last error to be kept")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !c.ready() || c.state != CircuitHalfOpen {
+		t.Fatal("Expected the circuit to be half-open after the retry delay")
+	}
+
+	// a failed probe opens the circuit again regardless of the threshold
+	c.failure(errors.New("connection refused"))
+	if c.state != CircuitOpen {
+		t.Fatal("Expected a failed probe to open the circuit")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !c.ready() {
+		t.Fatal("Expected the circuit to be ready for another probe")
+	}
+	c.success()
+	if c.state != CircuitClosed || c.err() != nil {
+		t.Fatal("Expected a successful probe to close the circuit")
+	}
+
+	want := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("got transitions %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("got transitions %v, want %v", transitions, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package multiplexer
+
+import (
+	"log/slog"
+	"time"
+)
+
+// lease gives one client exclusive access to the target server for several
+// consecutive requests, e.g. for a read-modify-write sequence.
+type lease struct {
+	// maxRequests is the number of requests a lease covers, 0 if leases are
+	// only taken by requests asking to hold the session.
+	maxRequests int
+	// timeout is how long a lease lasts at most after its first request.
+	timeout time.Duration
+
+	holder   *clientConn
+	requests int
+	expires  time.Time
+}
+
+func (l *lease) enabled() bool {
+	return l.timeout > 0
+}
+
+// check revokes the lease if it has timed out.
+func (l *lease) check(now time.Time) {
+	if l.holder != nil && !now.Before(l.expires) {
+		slog.Warn("revoking session lease", "id", l.holder.id, "requests", l.requests)
+		l.holder = nil
+	}
+}
+
+// served records that a request is sent to the target server, granting,
+// extending or releasing the lease.
+func (l *lease) served(container *reqContainer, now time.Time) {
+	if !l.enabled() || container.client == nil {
+		return
+	}
+	if l.holder == nil {
+		if l.maxRequests == 0 && !container.hold {
+			return
+		}
+		l.holder = container.client
+		l.requests = 0
+		l.expires = now.Add(l.timeout)
+		slog.Debug("granting session lease", "id", l.holder.id)
+	}
+	l.requests++
+	if !container.hold && (l.maxRequests == 0 || l.requests >= l.maxRequests) {
+		l.release(l.holder)
+	}
+}
+
+// release ends the lease if c holds it.
+func (l *lease) release(c *clientConn) {
+	if l.holder != nil && l.holder == c {
+		slog.Debug("releasing session lease", "id", c.id, "requests", l.requests)
+		l.holder = nil
+	}
+}
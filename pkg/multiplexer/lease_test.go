@@ -0,0 +1,78 @@
+package multiplexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	a := &clientConn{id: 1}
+	b := &clientConn{id: 2}
+	now := time.Now()
+
+	l := lease{maxRequests: 2, timeout: time.Second}
+	l.served(&reqContainer{client: a}, now)
+	if l.holder != a {
+		t.Fatal("Expected the first request to grant the lease")
+	}
+	l.served(&reqContainer{client: a}, now)
+	if l.holder != nil {
+		t.Fatal("Expected the lease to be released after 2 requests")
+	}
+
+	// a request asking to hold the session keeps the lease
+	l.served(&reqContainer{client: b}, now)
+	l.served(&reqContainer{client: b, hold: true}, now)
+	if l.holder != b {
+		t.Fatal("Expected the lease to be held")
+	}
+	l.check(now.Add(500 * time.Millisecond))
+	if l.holder != b {
+		t.Fatal("Expected the lease to be held before its timeout")
+	}
+	l.check(now.Add(time.Second))
+	if l.holder != nil {
+		t.Fatal("Expected the lease to be revoked after its timeout")
+	}
+
+	// with requests 0, only requests asking to hold take a lease
+	l = lease{timeout: time.Second}
+	l.served(&reqContainer{client: a}, now)
+	if l.holder != nil {
+		t.Fatal("Expected no lease without hold")
+	}
+	l.served(&reqContainer{client: a, hold: true}, now)
+	if l.holder != a {
+		t.Fatal("Expected hold to grant the lease")
+	}
+	l.release(b)
+	if l.holder != a {
+		t.Fatal("Expected only the holder to release the lease")
+	}
+	l.release(a)
+	if l.holder != nil {
+		t.Fatal("Expected the holder to release the lease")
+	}
+}
+
+func TestScheduler_PopHolder(t *testing.T) {
+	a := &clientConn{id: 1, class: defaultClass}
+	b := &clientConn{id: 2, class: defaultClass}
+
+	s := scheduler{}
+	s.push(&reqContainer{client: a})
+	s.push(&reqContainer{client: b})
+
+	if got := s.pop(b); got == nil || got.client != b {
+		t.Fatal("Expected the holder's request")
+	}
+	if s.has(b) {
+		t.Fatal("Expected no more requests of the holder")
+	}
+	if got := s.pop(b); got != nil {
+		t.Fatal("Expected no request for the holder")
+	}
+	if got := s.pop(nil); got == nil || got.client != a {
+		t.Fatal("Expected the remaining request")
+	}
+}
@@ -0,0 +1,85 @@
+package multiplexer
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+)
+
+// signOn sends the sign-on request of the target protocol, if it has one, on
+// a new target connection. It reports whether a sign-on was sent.
+func (mux *Multiplexer) signOn(conn targetConn) (bool, error) {
+	l, ok := mux.targetReader.(message.LinkManager)
+	if !ok {
+		return false, nil
+	}
+	req, err := l.SignOn()
+	if err != nil || req == nil {
+		return false, err
+	}
+	slog.Info("signing on to target server")
+	if err := mux.exchange(conn, nil, nil, l, req); err != nil {
+		return true, fmt.Errorf("sign-on failed: %w", err)
+	}
+	return true, nil
+}
+
+// keepAliveInterval returns how long the target connection may be idle before
+// a keepalive request is sent, or 0.
+func (mux *Multiplexer) keepAliveInterval() time.Duration {
+	if l, ok := mux.targetReader.(message.LinkManager); ok {
+		return l.KeepAliveInterval()
+	}
+	return 0
+}
+
+// keepAlive sends the keepalive request of the target protocol.
+func (mux *Multiplexer) keepAlive(conn targetConn, reader *backgroundReader, route func([]byte)) error {
+	l := mux.targetReader.(message.LinkManager)
+	req, err := l.KeepAlive()
+	if err != nil || req == nil {
+		return err
+	}
+	slog.Debug("sending keepalive to idle target server")
+	if err := mux.exchange(conn, reader, route, l, req); err != nil {
+		return fmt.Errorf("keepalive failed: %w", err)
+	}
+	return nil
+}
+
+// exchange sends a request of the multiplexer's own to the target server and
+// checks its response. Frames that do not answer the request are passed to
+// route if reader is set, and dropped otherwise.
+func (mux *Multiplexer) exchange(conn targetConn, reader *backgroundReader, route func([]byte), l message.LinkManager, req []byte) error {
+	err := conn.SetWriteDeadline(time.Now().Add(mux.responseTimeout))
+	if err != nil {
+		slog.Error("error setting write deadline", "error", err)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	correlator, _ := mux.targetReader.(message.Correlator)
+	var resp []byte
+	if reader != nil {
+		resp, err = reader.read(req, mux.responseTimeout, correlator, route)
+	} else {
+		err = conn.SetReadDeadline(time.Now().Add(mux.responseTimeout))
+		if err != nil {
+			slog.Error("error setting read deadline", "error", err)
+		}
+		for {
+			resp, err = message.ReadResponse(mux.targetReader, conn)
+			if err != nil || correlator == nil || correlator.Matches(req, resp) {
+				break
+			}
+			slog.Warn("dropping frame that does not answer the request", "frame", mux.logTarget(resp, false))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return l.Accepted(req, resp)
+}
@@ -0,0 +1,93 @@
+package multiplexer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+)
+
+// MaskRange is a range of bytes that is masked in every frame that is logged.
+type MaskRange struct {
+	Offset int
+	// Length is the number of masked bytes, 0 for the rest of the frame.
+	Length int
+}
+
+// ParseMaskRange parses OFFSET:LENGTH, or OFFSET: for the rest of the frame.
+func ParseMaskRange(s string) (MaskRange, error) {
+	offset, length, ok := strings.Cut(s, ":")
+	if !ok {
+		return MaskRange{}, fmt.Errorf("invalid mask range %q, want OFFSET:LENGTH", s)
+	}
+	var r MaskRange
+	var err error
+	r.Offset, err = strconv.Atoi(offset)
+	if err != nil || r.Offset < 0 {
+		return MaskRange{}, fmt.Errorf("invalid offset in mask range %q", s)
+	}
+	if length != "" {
+		r.Length, err = strconv.Atoi(length)
+		if err != nil || r.Length <= 0 {
+			return MaskRange{}, fmt.Errorf("invalid length in mask range %q", s)
+		}
+	}
+	return r, nil
+}
+
+// maskByte replaces masked bytes.
+const maskByte = '*'
+
+// loggedFrame is a frame that is decoded, or masked and hex encoded, only when
+// it is actually logged.
+type loggedFrame struct {
+	msg     []byte
+	reader  message.Reader
+	request bool
+	masks   []MaskRange
+}
+
+func (f loggedFrame) LogValue() slog.Value {
+	// decoded fields could reveal masked bytes
+	if d, ok := f.reader.(message.Describer); ok && len(f.masks) == 0 {
+		if fields, err := d.Describe(f.msg, f.request); err == nil {
+			attrs := make([]slog.Attr, len(fields))
+			for i, field := range fields {
+				attrs[i] = slog.Any(field.Name, field.Value)
+			}
+			return slog.GroupValue(attrs...)
+		}
+	}
+
+	msg := f.msg
+	if len(f.masks) > 0 {
+		msg = bytes.Clone(msg)
+		for _, r := range f.masks {
+			end := len(msg)
+			if r.Length > 0 {
+				end = min(r.Offset+r.Length, end)
+			}
+			for i := r.Offset; i < end; i++ {
+				msg[i] = maskByte
+			}
+		}
+	}
+	if m, ok := f.reader.(message.Masker); ok {
+		msg = m.Mask(msg)
+	}
+	return slog.GroupValue(slog.String("hex", hex.EncodeToString(msg)))
+}
+
+// logClient returns a request of a client for logging.
+func (mux *Multiplexer) logClient(msg []byte) slog.LogValuer {
+	return loggedFrame{msg: msg, reader: mux.messageReader, request: true, masks: mux.masks}
+}
+
+// logTarget returns a frame in the framing of the target server for logging.
+func (mux *Multiplexer) logTarget(msg []byte, request bool) slog.LogValuer {
+	return loggedFrame{msg: msg, reader: mux.targetReader, request: request, masks: mux.masks}
+}
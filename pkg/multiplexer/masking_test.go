@@ -0,0 +1,113 @@
+package multiplexer
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+)
+
+func TestParseMaskRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    MaskRange
+		wantErr bool
+	}{
+		{in: "6:4", want: MaskRange{Offset: 6, Length: 4}},
+		{in: "8:", want: MaskRange{Offset: 8}},
+		{in: "8", wantErr: true},
+		{in: "-1:4", wantErr: true},
+		{in: "2:0", wantErr: true},
+		{in: "a:b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMaskRange(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMaskRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMaskRange() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// render formats a logged value as the text handler does.
+func render(v slog.LogValuer) string {
+	var b bytes.Buffer
+	drop := func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key != "frame" {
+			return slog.Attr{}
+		}
+		return a
+	}
+	slog.New(slog.NewTextHandler(&b, &slog.HandlerOptions{ReplaceAttr: drop})).Info("", "frame", v)
+	return strings.TrimSpace(b.String())
+}
+
+func TestLoggedFrame(t *testing.T) {
+	pan, err := message.ISO8583MessageReader{}.Encode(&message.ISO8583Message{MTI: "0200", Fields: map[int]string{2: "4111111111111111", 11: "000001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modbus := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x10, 0x00, 0x02}
+
+	tests := []struct {
+		name  string
+		frame loggedFrame
+		want  string
+	}{
+		{
+			name:  "hex",
+			frame: loggedFrame{msg: []byte{0x01, 0x02, 0x03}, reader: message.RawMessageReader{}},
+			want:  "frame.hex=010203",
+		},
+		{
+			name:  "ranges",
+			frame: loggedFrame{msg: []byte{0x01, 0x02, 0x03, 0x04, 0x05}, reader: message.RawMessageReader{}, masks: []MaskRange{{Offset: 1, Length: 1}, {Offset: 3}}},
+			want:  "frame.hex=012a032a2a",
+		},
+		{
+			name:  "range beyond frame",
+			frame: loggedFrame{msg: []byte{0x01, 0x02}, reader: message.RawMessageReader{}, masks: []MaskRange{{Offset: 1, Length: 8}, {Offset: 4, Length: 2}}},
+			want:  "frame.hex=012a",
+		},
+		{
+			name:  "decoded",
+			frame: loggedFrame{msg: modbus, reader: message.ModbusMessageReader{}, request: true},
+			want:  `frame.transaction=7 frame.unit=1 frame.function="read holding registers (3)" frame.address=16 frame.quantity=2`,
+		},
+		{
+			name:  "decoded with card data",
+			frame: loggedFrame{msg: pan, reader: message.ISO8583MessageReader{}, request: true},
+			want:  `frame.mti=0200 frame.pan=411111******1111 frame.stan=000001 frame.fields="[2 11]"`,
+		},
+		{
+			name:  "masked ranges are not decoded",
+			frame: loggedFrame{msg: modbus, reader: message.ModbusMessageReader{}, request: true, masks: []MaskRange{{Offset: 8}}},
+			want:  "frame.hex=0007000000060103" + "2a2a2a2a",
+		},
+		{
+			name:  "undecodable with card data",
+			frame: loggedFrame{msg: pan, reader: message.ISO8583MessageReader{}, masks: []MaskRange{{Offset: 6, Length: 1}}},
+			want:  "frame.hex=" + fmt.Sprintf("%x", append(pan[:2:2], bytes.Repeat([]byte{'*'}, len(pan)-2)...)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := append([]byte{}, tt.frame.msg...)
+			if got := render(tt.frame); got != tt.want {
+				t.Errorf("LogValue() got = %s, want %s", got, tt.want)
+			}
+			if string(msg) != string(tt.frame.msg) {
+				t.Error("Expected the frame to be unchanged")
+			}
+		})
+	}
+}
@@ -1,14 +1,20 @@
 package multiplexer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+	"github.com/ingmarstein/tcp-multiplexer/pkg/serial"
 )
 
 type (
@@ -18,42 +24,329 @@ type (
 		typ     messageType
 		message []byte
 		sender  chan<- *respContainer
+		// deadline until which the request may wait for the target
+		// connection to be re-established.
+		deadline time.Time
+		// ctx is done once the request must no longer be sent to the
+		// target server.
+		ctx    context.Context
+		client *clientConn
+		// hold is set if the client asks to keep exclusive access to the
+		// target server for its next request.
+		hold bool
+		// session is set if the client gets the target connection for a raw
+		// byte pipe instead of sending a request.
+		session *rawSession
+		// seq and finish order the request in the scheduler.
+		seq    uint64
+		finish float64
+	}
+
+	// clientConn is a connected client.
+	clientConn struct {
+		id    int
+		class *Class
+		// lastFinish is the virtual finish time of the client's latest
+		// request for weighted fair queuing.
+		lastFinish float64
+		// push receives unsolicited frames from the target server.
+		push chan []byte
+		// done is closed once the client's connection handler returns.
+		done chan struct{}
+		// busy is set while the client waits for responses or a delayed
+		// request, and idleSince is when it last became idle in Unix
+		// nanoseconds. Both tell the request reader when the client has
+		// been idle for too long.
+		busy      atomic.Bool
+		idleSince atomic.Int64
 	}
 
 	respContainer struct {
 		message []byte
 		err     error
+		// unanswered is set if the target server does not answer the
+		// request, e.g. because it was broadcast.
+		unanswered bool
+		// local is set if the multiplexer answered the request itself in the
+		// client's framing.
+		local bool
+		// more is set for a response frame that is followed by further
+		// frames answering the same request.
+		more bool
 	}
 
 	Multiplexer struct {
-		targetServer  string
-		port          string
-		messageReader message.Reader
-		timeout       time.Duration
-		delay         time.Duration
-		retryDelay    time.Duration
-		l             net.Listener
-		quit          chan struct{}
-		wg            *sync.WaitGroup
-		requestQueue  chan *reqContainer
+		targetServer     string
+		serialConfig     *serial.Config
+		port             string
+		extraPorts       []string
+		classes          []Class
+		wfq              bool
+		clientQueueLimit int
+		limiter          *rateLimiter
+		admission        *admission
+		leaseRequests    int
+		leaseTimeout     time.Duration
+		unsolicited      UnsolicitedRoute
+		masks            []MaskRange
+		messageReader    message.Reader
+		targetReader     message.Reader
+		converter        message.Converter
+		timeout          time.Duration
+		connectTimeout   time.Duration
+		responseTimeout  time.Duration
+		idleTimeout      time.Duration
+		requestTimeout   time.Duration
+		delay            time.Duration
+		retryDelay       time.Duration
+		maxRetryDelay    time.Duration
+		queueTimeout     time.Duration
+		failureThreshold int
+		turnaround       time.Duration
+		pacer            pacer
+		l                net.Listener
+		extraListeners   []net.Listener
+		quit             chan struct{}
+		wg               *sync.WaitGroup
+		requestQueue     chan *reqContainer
+		stats            *stats
 	}
+
+	// targetConn is the connection to the target server, either a TCP
+	// connection or a local serial device.
+	targetConn interface {
+		io.ReadWriteCloser
+		SetReadDeadline(t time.Time) error
+		SetWriteDeadline(t time.Time) error
+	}
+
+	// Option configures optional behavior of a Multiplexer.
+	Option func(*Multiplexer)
 )
 
+// errClientDisconnected is the cause of the cancellation of requests whose
+// client has disconnected.
+var errClientDisconnected = errors.New("client disconnected")
+
 const (
 	Connection messageType = iota
 	Disconnection
 	Packet
 )
 
-func New(targetServer, port string, messageReader message.Reader, delay time.Duration, timeout time.Duration, retryDelay time.Duration) Multiplexer {
-	return Multiplexer{
-		targetServer:  targetServer,
-		port:          port,
-		messageReader: messageReader,
-		quit:          make(chan struct{}),
-		delay:         delay,
-		timeout:       timeout,
-		retryDelay:    retryDelay,
+func New(targetServer, port string, messageReader message.Reader, delay time.Duration, timeout time.Duration, retryDelay time.Duration, opts ...Option) Multiplexer {
+	mux := Multiplexer{
+		targetServer:    targetServer,
+		port:            port,
+		messageReader:   messageReader,
+		targetReader:    messageReader,
+		quit:            make(chan struct{}),
+		delay:           delay,
+		timeout:         timeout,
+		connectTimeout:  timeout,
+		responseTimeout: timeout,
+		idleTimeout:     timeout,
+		retryDelay:      retryDelay,
+		maxRetryDelay:   retryDelay,
+		stats:           &stats{},
+		admission:       &admission{},
+
+		failureThreshold: 1,
+		clientQueueLimit: 1,
+	}
+	for _, opt := range opts {
+		opt(&mux)
+	}
+	return mux
+}
+
+// WithTargetReader sets the protocol spoken by the target server if it differs
+// from the one spoken by the clients. Requests and responses are translated
+// between the two.
+func WithTargetReader(targetReader message.Reader) Option {
+	return func(mux *Multiplexer) {
+		mux.targetReader = targetReader
+	}
+}
+
+// WithSerial makes the multiplexer treat the target server as the path of a
+// local serial device configured with cfg.
+func WithSerial(cfg serial.Config) Option {
+	return func(mux *Multiplexer) {
+		mux.serialConfig = &cfg
+	}
+}
+
+// WithQueueTimeout lets requests wait up to timeout for the target connection
+// to be re-established instead of failing them while the target is in backoff.
+func WithQueueTimeout(timeout time.Duration) Option {
+	return func(mux *Multiplexer) {
+		mux.queueTimeout = timeout
+	}
+}
+
+// WithMaxRetryDelay lets the delay between attempts to connect to the target
+// server double after every failure, starting at retryDelay, up to maxDelay.
+func WithMaxRetryDelay(maxDelay time.Duration) Option {
+	return func(mux *Multiplexer) {
+		mux.maxRetryDelay = maxDelay
+	}
+}
+
+// WithFailureThreshold sets the number of consecutive failures to connect to,
+// write to or read from the target server after which the circuit breaker
+// opens and holds back requests until the retry delay has passed.
+func WithFailureThreshold(threshold int) Option {
+	return func(mux *Multiplexer) {
+		mux.failureThreshold = max(threshold, 1)
+	}
+}
+
+// WithTurnaroundDelay sets how long to wait after sending a broadcast, which
+// the target server does not answer, before sending the next request.
+func WithTurnaroundDelay(delay time.Duration) Option {
+	return func(mux *Multiplexer) {
+		mux.turnaround = delay
+	}
+}
+
+// WithRequestGap sets the minimum time between a response from the target
+// server and the next request. unitGaps sets the minimum time between a
+// response from a specific unit ID and the next request to that unit.
+func WithRequestGap(gap time.Duration, unitGaps map[byte]time.Duration) Option {
+	return func(mux *Multiplexer) {
+		mux.pacer.gap = gap
+		mux.pacer.unitGaps = unitGaps
+	}
+}
+
+// WithMaxRate limits the number of requests per second sent to the target
+// server.
+func WithMaxRate(rate float64) Option {
+	return func(mux *Multiplexer) {
+		if rate > 0 {
+			mux.pacer.interval = time.Duration(float64(time.Second) / rate)
+		}
+	}
+}
+
+// WithConnectTimeout sets the timeout for connecting to the target server.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(mux *Multiplexer) {
+		mux.connectTimeout = timeout
+	}
+}
+
+// WithResponseTimeout sets how long to wait for the target server to accept a
+// request and to answer it.
+func WithResponseTimeout(timeout time.Duration) Option {
+	return func(mux *Multiplexer) {
+		mux.responseTimeout = timeout
+	}
+}
+
+// WithIdleTimeout sets how long a client may stay idle before it is
+// disconnected.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(mux *Multiplexer) {
+		mux.idleTimeout = timeout
+	}
+}
+
+// WithRequestTimeout sets the total time a request may take from being read
+// from the client until it is sent to the target server, including the time
+// spent in the queue. Requests that exceed it are dropped.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(mux *Multiplexer) {
+		mux.requestTimeout = timeout
+	}
+}
+
+// WithExtraPorts makes the multiplexer listen on additional ports, e.g. to
+// assign clients to classes by the port they connect to.
+func WithExtraPorts(ports ...string) Option {
+	return func(mux *Multiplexer) {
+		mux.extraPorts = ports
+	}
+}
+
+// WithClasses assigns clients to the first matching class when they connect.
+// With wfq, requests are scheduled by weighted fair queuing across clients
+// using the class weights, otherwise by strict priority of the classes.
+func WithClasses(classes []Class, wfq bool) Option {
+	return func(mux *Multiplexer) {
+		mux.classes = classes
+		mux.wfq = wfq
+	}
+}
+
+// WithClientQueueLimit sets how many requests a client may have queued at the
+// same time. The multiplexer stops reading from a client that reaches the
+// limit until one of its requests has been answered.
+func WithClientQueueLimit(limit int) Option {
+	return func(mux *Multiplexer) {
+		mux.clientQueueLimit = max(limit, 1)
+	}
+}
+
+// WithRateLimits limits the requests and request bytes per second of each
+// client and of all clients from the same source IP. action determines what
+// happens to requests exceeding a limit.
+func WithRateLimits(client, ip RateLimit, action RateLimitAction) Option {
+	return func(mux *Multiplexer) {
+		if client.enabled() || ip.enabled() {
+			mux.limiter = &rateLimiter{client: client, ip: ip, action: action}
+		}
+	}
+}
+
+// WithMaxClients limits the number of concurrently connected clients overall
+// and per source IP. Zero means unlimited.
+func WithMaxClients(total, perIP int) Option {
+	return func(mux *Multiplexer) {
+		mux.admission.maxClients = total
+		mux.admission.maxClientsPerIP = perIP
+	}
+}
+
+// WithAccessControl rejects clients connecting from a denied network and, if
+// allow is not empty, from outside the allowed networks.
+func WithAccessControl(allow, deny []*net.IPNet) Option {
+	return func(mux *Multiplexer) {
+		mux.admission.allow = allow
+		mux.admission.deny = deny
+	}
+}
+
+// WithSessionLease gives a client exclusive access to the target server for up
+// to requests consecutive requests, starting with any of its requests. The
+// lease is revoked if it is not released within timeout of its first request.
+// With requests 0, only requests that ask to hold the session (see
+// message.SessionHolder) take a lease. A request asking to hold the session
+// keeps the lease for the client's next request.
+func WithSessionLease(requests int, timeout time.Duration) Option {
+	return func(mux *Multiplexer) {
+		mux.leaseRequests = requests
+		mux.leaseTimeout = timeout
+	}
+}
+
+// WithUnsolicited reads from the target server in the background and routes
+// frames that do not answer the pending request as configured. Protocols that
+// implement message.Correlator tell responses and unsolicited frames apart,
+// for the others every frame that arrives while a request is pending is taken
+// as its response.
+func WithUnsolicited(route UnsolicitedRoute) Option {
+	return func(mux *Multiplexer) {
+		mux.unsolicited = route
+	}
+}
+
+// WithLogMasks masks byte ranges of every frame that is logged, in addition to
+// the sensitive data protocols mask themselves (see message.Masker).
+func WithLogMasks(ranges ...MaskRange) Option {
+	return func(mux *Multiplexer) {
+		mux.masks = ranges
 	}
 }
 
@@ -63,10 +356,28 @@ func (mux *Multiplexer) deadline() time.Time {
 
 func (mux *Multiplexer) Start() error {
 	var err error
+	if mux.targetReader.Name() != mux.messageReader.Name() {
+		mux.converter, err = message.NewConverter(mux.messageReader, mux.targetReader)
+		if err != nil {
+			return err
+		}
+	}
+
 	mux.l, err = net.Listen("tcp", ":"+mux.port)
 	if err != nil {
 		return err
 	}
+	for _, port := range mux.extraPorts {
+		l, err := net.Listen("tcp", ":"+port)
+		if err != nil {
+			_ = mux.l.Close()
+			for _, l := range mux.extraListeners {
+				_ = l.Close()
+			}
+			return err
+		}
+		mux.extraListeners = append(mux.extraListeners, l)
+	}
 
 	var wg sync.WaitGroup
 	mux.wg = &wg
@@ -79,97 +390,393 @@ func (mux *Multiplexer) Start() error {
 		mux.targetConnLoop(requestQueue)
 	}()
 
-	count := 0
-L:
+	// connection ids are unique across all listeners
+	var count atomic.Int64
+	for _, l := range mux.extraListeners {
+		go mux.acceptLoop(l, requestQueue, &count)
+	}
+	mux.acceptLoop(mux.l, requestQueue, &count)
+	return nil
+}
+
+func (mux *Multiplexer) acceptLoop(l net.Listener, requestQueue chan<- *reqContainer, count *atomic.Int64) {
 	for {
-		conn, err := mux.l.Accept()
+		conn, err := l.Accept()
 		if err != nil {
 			select {
 			case <-mux.quit:
-				slog.Info("no more connections will be accepted")
-				return nil
+				slog.Info("no more connections will be accepted", "local", l.Addr())
+				return
 			default:
 				slog.Error("accept error", "error", err)
-				goto L
+				continue
 			}
 		}
-		count++
-		slog.Info("new connection", "id", count, "remote", conn.RemoteAddr(), "local", conn.LocalAddr())
+		ip := conn.RemoteAddr().(*net.TCPAddr).IP
+		if err := mux.admission.admit(ip); err != nil {
+			mux.stats.rejected.Add(1)
+			slog.Warn("rejecting connection", "remote", conn.RemoteAddr(), "local", conn.LocalAddr(), "reason", err)
+			if err := conn.Close(); err != nil {
+				slog.Error("error closing client connection", "error", err)
+			}
+			continue
+		}
 
-		wg.Go(func() {
-			mux.handleConnection(conn, requestQueue)
+		c := &clientConn{
+			id:    int(count.Add(1)),
+			class: classify(mux.classes, conn.LocalAddr(), conn.RemoteAddr()),
+			push:  make(chan []byte, 8),
+			done:  make(chan struct{}),
+		}
+		c.idleSince.Store(time.Now().UnixNano())
+		slog.Info("new connection", "id", c.id, "remote", conn.RemoteAddr(), "local", conn.LocalAddr(), "class", c.class.Name)
+
+		mux.wg.Go(func() {
+			defer mux.admission.release(ip)
+			if gap, ok := mux.streamer(); ok {
+				mux.handleRawConnection(conn, requestQueue, c, gap)
+				return
+			}
+			mux.handleConnection(conn, requestQueue, c)
 		})
 	}
 }
 
-func (mux *Multiplexer) handleConnection(conn net.Conn, sender chan<- *reqContainer) {
+func (mux *Multiplexer) handleConnection(conn net.Conn, sender chan<- *reqContainer, client *clientConn) {
+	// ctx is canceled once the client disconnects, so that its queued
+	// requests are not sent to the target server anymore
+	ctx, cancel := context.WithCancelCause(context.Background())
+	requests := make(chan []byte)
+
 	defer func(c net.Conn) {
 		slog.Debug("closing client connection", "remote", c.RemoteAddr())
+		close(client.done)
+		cancel(errClientDisconnected)
 		err := c.Close()
-		sender <- &reqContainer{typ: Disconnection}
+		sender <- &reqContainer{typ: Disconnection, client: client}
 		if err != nil {
 			slog.Error("error closing client connection", "error", err)
 		}
 	}(conn)
 
-	sender <- &reqContainer{typ: Connection}
-	callback := make(chan *respContainer, 1)
+	sender <- &reqContainer{typ: Connection, client: client}
+
+	go mux.readRequests(conn, client, requests, cancel)
+
+	var limiter *clientLimiter
+	if mux.limiter != nil {
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		limiter = mux.limiter.join(host)
+		defer limiter.leave()
+	}
+
+	// requests of this client that have been queued, in order
+	type queued struct {
+		msg      []byte
+		callback chan *respContainer
+		cancel   context.CancelFunc
+	}
+	var inflight []queued
+
+	// enqueue sends a request of the client to the target conn loop
+	enqueue := func(msg []byte) bool {
+		req := msg
+		if mux.converter != nil {
+			var err error
+			req, err = mux.converter.Request(msg)
+			if err != nil {
+				slog.Error("error converting request", "error", err)
+				return false
+			}
+		}
+
+		reqCtx, reqCancel := ctx, context.CancelFunc(func() {})
+		if mux.requestTimeout > 0 {
+			reqCtx, reqCancel = context.WithTimeout(ctx, mux.requestTimeout)
+		}
+		callback := make(chan *respContainer, 1)
+
+		hold := false
+		if h, ok := mux.messageReader.(message.SessionHolder); ok && mux.leaseTimeout > 0 {
+			hold = h.HoldSession(msg)
+		}
+
+		sender <- &reqContainer{
+			typ:      Packet,
+			message:  req,
+			sender:   callback,
+			deadline: time.Now().Add(mux.queueTimeout),
+			ctx:      reqCtx,
+			client:   client,
+			hold:     hold,
+		}
+		inflight = append(inflight, queued{msg: msg, callback: callback, cancel: reqCancel})
+		return true
+	}
+
+	// a rate limited request waits for its delay without blocking the
+	// client's responses
+	var delayed []byte
+	var delay <-chan time.Time
 
 	for {
-		err := conn.SetReadDeadline(mux.deadline())
-		if err != nil {
-			slog.Error("error setting read deadline", "error", err)
+		var err error
+		// do not treat the wait for responses as idle time
+		client.setBusy(len(inflight) > 0 || delayed != nil)
+
+		// stop reading from the client while it has reached its queue limit
+		// or a request is delayed
+		var next <-chan []byte
+		var canceled <-chan struct{}
+		if delayed != nil {
+			canceled = ctx.Done()
+		} else if len(inflight) < mux.clientQueueLimit {
+			next = requests
 		}
-		msg, err := mux.messageReader.ReadMessage(conn)
-		if err == io.EOF {
-			slog.Info("closed connection", "remote", conn.RemoteAddr(), "local", conn.LocalAddr())
-			break
+		var head <-chan *respContainer
+		if len(inflight) > 0 {
+			head = inflight[0].callback
 		}
-		if err != nil {
-			slog.Error("error reading from client", "error", err)
-			break
+
+		select {
+		case msg, ok := <-next:
+			if !ok {
+				return
+			}
+
+			slog.Debug("message from client", "frame", mux.logClient(msg))
+
+			if limiter != nil {
+				wait, reply, ok := mux.limit(limiter, client, msg)
+				if !ok {
+					return
+				}
+				if reply != nil {
+					// answer in order with the client's other requests
+					callback := make(chan *respContainer, 1)
+					callback <- &respContainer{message: reply, local: true}
+					inflight = append(inflight, queued{msg: msg, callback: callback, cancel: func() {}})
+					continue
+				}
+				if wait > 0 {
+					delayed, delay = msg, time.After(wait)
+					continue
+				}
+			}
+
+			if !enqueue(msg) {
+				return
+			}
+
+		case <-delay:
+			msg := delayed
+			delayed, delay = nil, nil
+			if !enqueue(msg) {
+				return
+			}
+
+		case <-canceled:
+			return
+
+		case resp := <-head:
+			// get response from target conn loop
+			msg := inflight[0].msg
+			if !resp.more {
+				inflight[0].cancel()
+				inflight = inflight[1:]
+			}
+			if resp.err != nil {
+				slog.Error("failed to forward message", "error", resp.err)
+				return
+			}
+
+			switch {
+			case resp.local:
+				// the reply is already in the client's framing
+			case resp.unanswered:
+				a, ok := mux.messageReader.(message.Acknowledger)
+				if !ok {
+					// the client does not expect a reply
+					continue
+				}
+				resp.message, err = a.Acknowledge(msg)
+				if err != nil {
+					slog.Error("error acknowledging broadcast", "error", err)
+					return
+				}
+			case mux.converter != nil:
+				resp.message, err = mux.converter.Response(msg, resp.message)
+				if err != nil {
+					slog.Error("error converting response", "error", err)
+					return
+				}
+			}
+
+			// write back
+			if !mux.write(conn, resp.message) {
+				return
+			}
+
+		case msg := <-client.push:
+			slog.Debug("unsolicited message to client", "id", client.id, "frame", mux.logTarget(msg, false))
+			if !mux.write(conn, msg) {
+				return
+			}
 		}
+	}
+}
 
-		slog.Debug("message from client", "hex", fmt.Sprintf("%x", msg))
+// write sends msg to a client and reports whether that succeeded.
+func (mux *Multiplexer) write(conn net.Conn, msg []byte) bool {
+	err := conn.SetWriteDeadline(mux.deadline())
+	if err != nil {
+		slog.Error("error setting write deadline", "error", err)
+	}
+	_, err = conn.Write(msg)
+	if err != nil {
+		slog.Error("error writing to client", "error", err)
+		return false
+	}
+	return true
+}
 
-		// enqueue request msg to target conn loop
-		sender <- &reqContainer{
-			typ:     Packet,
-			message: msg,
-			sender:  callback,
+// limit applies the rate limits to a request of client c. It returns false if
+// the client has to be disconnected, the time by which the request has to be
+// delayed, and a reply if the multiplexer answers the request itself instead
+// of forwarding it.
+func (mux *Multiplexer) limit(limiter *clientLimiter, c *clientConn, msg []byte) (time.Duration, []byte, bool) {
+	if mux.limiter.action == RateLimitDelay {
+		wait := limiter.reserve(len(msg))
+		if wait > 0 {
+			mux.stats.limited.Add(1)
+			slog.Debug("delaying rate limited request", "id", c.id, "delay", wait)
 		}
+		return wait, nil, true
+	}
 
-		// get response from target conn loop
-		resp := <-callback
-		if resp.err != nil {
-			slog.Error("failed to forward message", "error", resp.err)
-			break
+	if limiter.allow(len(msg)) {
+		return 0, nil, true
+	}
+	mux.stats.limited.Add(1)
+	if mux.limiter.action == RateLimitBusy {
+		if b, ok := mux.messageReader.(message.BusyResponder); ok {
+			reply, err := b.Busy(msg)
+			if err == nil {
+				slog.Info("rejecting rate limited request", "id", c.id)
+				return 0, reply, true
+			}
+			slog.Error("error creating busy reply", "error", err)
 		}
+	}
+	slog.Warn("disconnecting rate limited client", "id", c.id, "action", mux.limiter.action)
+	return 0, nil, false
+}
 
-		// write back
-		err = conn.SetWriteDeadline(mux.deadline())
-		if err != nil {
-			slog.Error("error setting write deadline", "error", err)
+// setBusy records whether the client waits for responses.
+func (c *clientConn) setBusy(busy bool) {
+	if busy {
+		c.busy.Store(true)
+	} else if c.busy.CompareAndSwap(true, false) {
+		c.idleSince.Store(time.Now().UnixNano())
+	}
+}
+
+// idleDeadline returns when the client will have been idle for timeout,
+// checking again after timeout while it is busy.
+func (c *clientConn) idleDeadline(timeout time.Duration) time.Time {
+	if c.busy.Load() {
+		return time.Now().Add(timeout)
+	}
+	return time.Unix(0, c.idleSince.Load()).Add(timeout)
+}
+
+// countingConn counts the bytes read from a connection.
+type countingConn struct {
+	net.Conn
+	n int
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.n += n
+	return n, err
+}
+
+// readRequests reads requests from a client and passes them on until the
+// client disconnects, which cancels the client's context. It is the only one
+// to set the client's read deadline, so that readers can use read deadlines
+// within a frame, and disconnects the client once it has been idle for the
+// idle timeout.
+func (mux *Multiplexer) readRequests(conn net.Conn, client *clientConn, requests chan<- []byte, cancel context.CancelCauseFunc) {
+	defer close(requests)
+
+	r := &countingConn{Conn: conn}
+	for {
+		if err := conn.SetReadDeadline(client.idleDeadline(mux.idleTimeout)); err != nil {
+			slog.Error("error setting read deadline", "error", err)
+		}
+		r.n = 0
+		msg, err := message.ReadRequest(mux.messageReader, r)
+		if errors.Is(err, os.ErrDeadlineExceeded) && r.n == 0 && time.Now().Before(client.idleDeadline(mux.idleTimeout)) {
+			// the client has been waiting for responses in the meantime
+			continue
+		}
+		if err == io.EOF {
+			slog.Info("closed connection", "remote", conn.RemoteAddr(), "local", conn.LocalAddr())
+			cancel(errClientDisconnected)
+			return
 		}
-		_, err = conn.Write(resp.message)
 		if err != nil {
-			slog.Error("error writing to client", "error", err)
-			break
+			select {
+			case <-client.done:
+				// the connection was closed by handleConnection
+			default:
+				slog.Error("error reading from client", "error", err)
+			}
+			cancel(errClientDisconnected)
+			return
+		}
+
+		client.setBusy(true)
+		select {
+		case requests <- msg:
+		case <-client.done:
+			return
 		}
 	}
 }
 
-func (mux *Multiplexer) createTargetConn() (net.Conn, error) {
+func (mux *Multiplexer) createTargetConn() (targetConn, error) {
 	slog.Info("creating target connection")
-	conn, err := net.DialTimeout("tcp", mux.targetServer, mux.timeout)
+	var conn targetConn
+	if mux.serialConfig != nil {
+		port, err := serial.Open(mux.targetServer, *mux.serialConfig)
+		if err != nil {
+			slog.Error("failed to open target serial device", "device", mux.targetServer, "error", err)
+			return nil, err
+		}
+		slog.Info("new target connection", "device", mux.targetServer, "baudRate", mux.serialConfig.BaudRate)
+		conn = port
+	} else {
+		c, err := net.DialTimeout("tcp", mux.targetServer, mux.connectTimeout)
+		if err != nil {
+			slog.Error("failed to connect to target server", "server", mux.targetServer, "error", err)
+			return nil, err
+		}
+		slog.Info("new target connection", "local", c.LocalAddr(), "remote", c.RemoteAddr())
+		conn = c
+	}
+
+	// a sign-on tells when the target is ready, so there is no need to wait
+	signedOn, err := mux.signOn(conn)
 	if err != nil {
-		slog.Error("failed to connect to target server", "server", mux.targetServer, "error", err)
+		slog.Error("failed to sign on to target server", "error", err)
+		if err := conn.Close(); err != nil {
+			slog.Error("error closing target connection", "error", err)
+		}
 		return nil, err
 	}
-
-	slog.Info("new target connection", "local", conn.LocalAddr(), "remote", conn.RemoteAddr())
-
-	if mux.delay > 0 {
+	if !signedOn && mux.delay > 0 {
 		slog.Info("waiting before using new target connection", "delay", mux.delay)
 		time.Sleep(mux.delay)
 	}
@@ -178,107 +785,349 @@ func (mux *Multiplexer) createTargetConn() (net.Conn, error) {
 }
 
 func (mux *Multiplexer) targetConnLoop(requestQueue <-chan *reqContainer) {
-	var conn net.Conn
+	var conn targetConn
+	// reader is set while unsolicited frames are read in the background
+	var reader *backgroundReader
+	var connected []*clientConn
+	closeConn := func() {
+		err := conn.Close()
+		if err != nil {
+			slog.Error("error closing target connection", "error", err)
+		}
+		conn = nil
+		if reader != nil {
+			reader.stop()
+			reader = nil
+		}
+	}
+	route := func(msg []byte) {
+		mux.route(msg, connected, conn)
+	}
+	// lastUsed is when a request was last sent to the target server, so that
+	// idle links are kept alive
+	var lastUsed time.Time
+	keepAlive := mux.keepAliveInterval()
 	clients := 0
-	nextRetry := time.Now()
-	var lastErr error
+	circuit := circuitBreaker{
+		threshold: mux.failureThreshold,
+		retry:     backoff{initial: mux.retryDelay, max: mux.maxRetryDelay},
+		onChange: func(state CircuitState) {
+			mux.stats.circuit.Store(int32(state))
+		},
+	}
+	// requests waiting to be sent to the target server
+	queue := scheduler{wfq: mux.wfq}
+	lease := lease{maxRequests: mux.leaseRequests, timeout: mux.leaseTimeout}
 
-	for container := range requestQueue {
+	receive := func(container *reqContainer) {
 		switch container.typ {
 		case Connection:
+			connected = append(connected, container.client)
 			clients++
+			mux.stats.clients.Store(int64(clients))
 			slog.Info("connected clients", "count", clients)
-			continue
 		case Disconnection:
+			connected = slices.DeleteFunc(connected, func(c *clientConn) bool { return c == container.client })
 			clients--
+			mux.stats.clients.Store(int64(clients))
 			slog.Info("connected clients", "count", clients)
+			lease.release(container.client)
 			if clients == 0 && conn != nil {
 				slog.Info("closing target connection")
-				err := conn.Close()
-				if err != nil {
-					slog.Error("error closing target connection", "error", err)
+				closeConn()
+			}
+		case Packet:
+			queue.push(container)
+		}
+	}
+
+	for {
+		lease.check(time.Now())
+		// while a client holds the lease, requests of other clients wait
+		if queue.len() == 0 || !circuit.ready() || !queue.has(lease.holder) {
+			var wakeup <-chan time.Time
+			if queue.len() > 0 {
+				var at time.Time
+				if circuit.waiting() {
+					at = nextWakeup(circuit.nextProbe(), queue.queue)
+				}
+				if lease.holder != nil && (at.IsZero() || lease.expires.Before(at)) {
+					at = lease.expires
+				}
+				if !at.IsZero() {
+					wakeup = time.After(time.Until(at))
+				}
+			}
+
+			var frames <-chan targetFrame
+			if reader != nil {
+				frames = reader.frames
+			}
+			var idle <-chan time.Time
+			if conn != nil && keepAlive > 0 {
+				idle = time.After(time.Until(lastUsed.Add(keepAlive)))
+			}
+
+			select {
+			case container, ok := <-requestQueue:
+				if !ok {
+					slog.Info("target connection write/read loop stopped gracefully")
+					return
+				}
+				receive(container)
+			case f := <-frames:
+				if f.err != nil {
+					slog.Warn("target connection closed while idle", "error", f.err)
+					closeConn()
+					break
+				}
+				route(f.msg)
+			case <-idle:
+				lastUsed = time.Now()
+				if err := mux.keepAlive(conn, reader, route); err != nil {
+					slog.Error("target connection lost", "error", err)
+					circuit.failure(err)
+					closeConn()
 				}
-				conn = nil
+			case <-wakeup:
+			}
+
+			if circuit.state == CircuitOpen || circuit.waiting() {
+				mux.expire(&queue, circuit.err())
 			}
 			continue
-		case Packet:
 		}
 
-		if conn == nil {
-			if time.Now().Before(nextRetry) {
-				container.sender <- &respContainer{
-					err: lastErr,
+		// take in everything that is queued so that the scheduler can pick
+		// the next request among all waiting clients
+	drain:
+		for {
+			select {
+			case container, ok := <-requestQueue:
+				if !ok {
+					break drain
 				}
-				continue
+				receive(container)
+			default:
+				break drain
 			}
+		}
+		container := queue.pop(lease.holder)
+		if container == nil {
+			continue
+		}
+
+		if container.ctx.Err() != nil {
+			mux.drop(container)
+			continue
+		}
 
+		if conn == nil {
 			c, err := mux.createTargetConn()
 			if err != nil {
-				lastErr = fmt.Errorf("failed to connect to target, entering backoff: %w", err)
-				if mux.retryDelay > 0 {
-					nextRetry = time.Now().Add(mux.retryDelay)
-				}
-				container.sender <- &respContainer{
-					err: lastErr,
-				}
+				circuit.failure(fmt.Errorf("failed to connect to target: %w", err))
+				// keep the request queued until its deadline
+				queue.requeue(container)
+				mux.expire(&queue, circuit.err())
 				continue
 			}
 			conn = c
+			lastUsed = time.Now()
+			_, streaming := mux.streamer()
+			if mux.unsolicited.Action != UnsolicitedDisabled && !streaming {
+				reader = mux.startReader(conn)
+			}
 		}
 
-		err := conn.SetWriteDeadline(mux.deadline())
-		if err != nil {
-			slog.Error("error setting write deadline", "error", err)
+		sent := time.Now()
+		var err error
+		if container.session != nil {
+			var granted bool
+			granted, err = mux.lend(conn, container)
+			if !granted {
+				continue
+			}
+		} else {
+			err = mux.forward(conn, reader, route, container)
 		}
-
-		_, err = conn.Write(container.message)
+		lastUsed = time.Now()
 		if err != nil {
-			container.sender <- &respContainer{
-				err: err,
-			}
-
-			slog.Error("target connection error during write", "error", err)
+			mux.stats.failed.Add(1)
+			circuit.failure(err)
 			// renew conn
-			err = conn.Close()
-			if err != nil {
-				slog.Error("error while closing connection", "error", err)
-			}
-			conn = nil
+			closeConn()
 			continue
 		}
+		mux.stats.forwarded.Add(1)
+		circuit.success()
+		// a request that failed does not take or extend a lease
+		lease.served(container, sent)
+	}
+}
 
-		err = conn.SetReadDeadline(mux.deadline())
-		if err != nil {
-			slog.Error("error setting read deadline", "error", err)
+// nextWakeup returns when the target loop has to act on pending requests:
+// when the circuit lets a probe through or when the first request expires.
+func nextWakeup(nextProbe time.Time, pending []*reqContainer) time.Time {
+	wakeup := nextProbe
+	for _, container := range pending {
+		if container.deadline.Before(wakeup) {
+			wakeup = container.deadline
+		}
+		if d, ok := container.ctx.Deadline(); ok && d.Before(wakeup) {
+			wakeup = d
+		}
+	}
+	return wakeup
+}
+
+// expire fails all queued requests that cannot wait any longer for the
+// target connection.
+func (mux *Multiplexer) expire(queue *scheduler, err error) {
+	now := time.Now()
+	queue.filter(func(container *reqContainer) bool {
+		if container.ctx.Err() != nil {
+			mux.drop(container)
+			return false
+		}
+		if now.Before(container.deadline) {
+			return true
+		}
+		mux.stats.failed.Add(1)
+		container.sender <- &respContainer{
+			err: err,
+		}
+		return false
+	})
+}
+
+// drop skips a request that must not be sent to the target server anymore,
+// either because its client has disconnected or because it has expired.
+func (mux *Multiplexer) drop(container *reqContainer) {
+	cause := context.Cause(container.ctx)
+	if errors.Is(cause, errClientDisconnected) {
+		mux.stats.canceled.Add(1)
+		slog.Info("skipping request of disconnected client", "skipped", mux.stats.canceled.Load())
+	} else {
+		mux.stats.expired.Add(1)
+		slog.Warn("dropping expired request", "reason", cause, "expired", mux.stats.expired.Load())
+	}
+	container.sender <- &respContainer{
+		err: fmt.Errorf("request dropped: %w", cause),
+	}
+}
+
+// readResponse reads the response to req from the target server without a
+// background reader. Frames that do not answer req are discarded until the
+// response timeout, so that a client never gets the response to another
+// transaction.
+func (mux *Multiplexer) readResponse(conn targetConn, req []byte, correlator message.Correlator) ([]byte, error) {
+	err := conn.SetReadDeadline(time.Now().Add(mux.responseTimeout))
+	if err != nil {
+		slog.Error("error setting read deadline", "error", err)
+	}
+	for {
+		frame, err := message.ReadResponse(mux.targetReader, conn)
+		if err != nil || correlator == nil || correlator.Matches(req, frame) {
+			return frame, err
 		}
+		slog.Warn("discarding response that does not match request", "request", mux.logTarget(req, true), "response", mux.logTarget(frame, false))
+	}
+}
+
+// forward sends a request to the target server and passes the response back
+// to the client. It returns an error if the target connection is broken. If
+// reader is set, responses are taken from it and unsolicited frames are passed
+// to route.
+func (mux *Multiplexer) forward(conn targetConn, reader *backgroundReader, route func([]byte), container *reqContainer) error {
+	var unit byte
+	hasUnit := false
+	if a, ok := mux.targetReader.(message.Addresser); ok {
+		unit, hasUnit = a.UnitID(container.message)
+	}
+	mux.pacer.wait(unit, hasUnit)
 
-		msg, err := mux.messageReader.ReadMessage(conn)
+	err := conn.SetWriteDeadline(time.Now().Add(mux.responseTimeout))
+	if err != nil {
+		slog.Error("error setting write deadline", "error", err)
+	}
+
+	_, err = conn.Write(container.message)
+	mux.pacer.sent()
+	if err != nil {
 		container.sender <- &respContainer{
-			message: msg,
-			err:     err,
+			err: err,
 		}
 
-		slog.Debug("message from target server", "hex", fmt.Sprintf("%x", msg))
+		slog.Error("target connection error during write", "error", err)
+		return err
+	}
+
+	responses := message.ExpectedResponses(mux.targetReader, container.message)
+	if responses == message.NoResponse {
+		// devices on a bus need time to process a broadcast, other requests
+		// without a response are followed by the next one right away
+		if b, ok := mux.targetReader.(message.Broadcaster); ok && b.IsBroadcast(container.message) {
+			slog.Debug("broadcast is not answered, not waiting for a response", "turnaroundDelay", mux.turnaround)
+			time.Sleep(mux.turnaround)
+		} else {
+			slog.Debug("request is not answered, not waiting for a response")
+		}
+		mux.pacer.received(unit, hasUnit)
+		container.sender <- &respContainer{
+			unanswered: true,
+		}
+		return nil
+	}
 
+	// pass the frames of requests answered by several frames to the client
+	// as they arrive
+	reply := func(resp *respContainer) {
+		container.sender <- resp
+	}
+	exchanger, _ := mux.targetReader.(message.Exchanger)
+	if responses == message.UntilFinal && exchanger != nil {
+		reply = newRelay(container.sender, container.client.done).send
+	}
+	correlator, _ := mux.targetReader.(message.Correlator)
+	var frame []byte
+	for {
+		if reader != nil {
+			frame, err = reader.read(container.message, mux.responseTimeout, correlator, route)
+		} else {
+			frame, err = mux.readResponse(conn, container.message, correlator)
+		}
 		if err != nil {
-			slog.Error("target connection error during read", "error", err)
-			// renew conn
-			err = conn.Close()
-			if err != nil {
-				slog.Error("error while closing connection", "error", err)
-			}
-			conn = nil
-			continue
+			break
 		}
+		slog.Debug("message from target server", "frame", mux.logTarget(frame, false))
+		if responses != message.UntilFinal || exchanger == nil || exchanger.IsFinal(container.message, frame) {
+			break
+		}
+		reply(&respContainer{message: frame, more: true})
 	}
+	mux.pacer.received(unit, hasUnit)
+	reply(&respContainer{
+		message: frame,
+		err:     err,
+	})
 
-	slog.Info("target connection write/read loop stopped gracefully")
+	if err != nil {
+		slog.Error("target connection error during read", "error", err)
+		return err
+	}
+	return nil
 }
 
 // Close graceful shutdown.
 func (mux *Multiplexer) Close() error {
 	close(mux.quit)
 	slog.Info("closing server")
+	for _, l := range mux.extraListeners {
+		err := l.Close()
+		if err != nil {
+			return err
+		}
+	}
 	err := mux.l.Close()
 	if err != nil {
 		return err
@@ -3,11 +3,16 @@ package multiplexer
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -127,3 +132,1202 @@ func TestMultiplexer_Start(t *testing.T) {
 		t.Fatal("Expected no error, but got:", err)
 	}
 }
+
+func startMultiplexer(t *testing.T, mux *Multiplexer) {
+	t.Helper()
+	go func() {
+		if err := mux.Start(); err != nil {
+			t.Error("Expected no error, but got:", err)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	t.Cleanup(func() { _ = mux.Close() })
+}
+
+// modbusSerialTarget serves raw Modbus RTU over TCP. It answers every read
+// holding registers request with a single register and every other request
+// with an echo, except broadcasts, which it records without answering.
+func modbusSerialTarget(t *testing.T, delay time.Duration) (string, <-chan []byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	broadcasts := make(chan []byte, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				for {
+					req, err := message.ModbusSerialMessageReader{}.ReadRequest(conn)
+					if err != nil {
+						return
+					}
+					if req[0] == 0 {
+						broadcasts <- req
+						continue
+					}
+					time.Sleep(delay)
+					resp := req
+					if req[1] == 3 {
+						resp = appendCRC([]byte{req[0], 0x03, 0x02, 0x00, 0x2A})
+					}
+					if _, err := conn.Write(resp); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String(), broadcasts
+}
+
+func appendCRC(frame []byte) []byte {
+	var crc uint16 = 0xFFFF
+	for _, b := range frame {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return append(frame, byte(crc), byte(crc>>8))
+}
+
+func TestMultiplexer_ModbusGatewayBroadcast(t *testing.T) {
+	target, broadcasts := modbusSerialTarget(t, 0)
+
+	mux := New(target, "1236", message.ModbusMessageReader{}, 0, 2*time.Second, time.Second,
+		WithTargetReader(message.ModbusSerialMessageReader{}),
+		WithTurnaroundDelay(10*time.Millisecond))
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1236")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	exchange := func(req, want []byte) {
+		t.Helper()
+		if _, err := conn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := message.ModbusMessageReader{}.ReadMessage(conn)
+		if err != nil {
+			t.Fatal("Expected no error, but got:", err)
+		}
+		if !bytes.Equal(resp, want) {
+			t.Fatalf("got %x, want %x", resp, want)
+		}
+	}
+
+	// broadcast write to unit 0 is acknowledged without a response from the target
+	broadcast := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x00, 0x06, 0x00, 0x01, 0x00, 0x03}
+	exchange(broadcast, broadcast)
+	select {
+	case req := <-broadcasts:
+		if want := appendCRC([]byte{0x00, 0x06, 0x00, 0x01, 0x00, 0x03}); !bytes.Equal(req, want) {
+			t.Fatalf("target got %x, want %x", req, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("broadcast did not reach the target")
+	}
+
+	// the target connection is still usable
+	exchange([]byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2A})
+}
+
+func TestMultiplexer_ClientSilenceFraming(t *testing.T) {
+	target, _ := modbusSerialTarget(t, 0)
+
+	mux := New(target, "1253", message.ModbusSilenceMessageReader{Silence: 20 * time.Millisecond}, 0, 2*time.Second, time.Second,
+		WithTargetReader(message.ModbusSerialMessageReader{}))
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1253")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	req := appendCRC([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01})
+	want := appendCRC([]byte{0x01, 0x03, 0x02, 0x00, 0x2A})
+	for i := range 2 {
+		if _, err := conn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := message.ModbusSerialMessageReader{}.ReadResponse(conn)
+		if err != nil {
+			t.Fatalf("request %d: Expected no error, but got: %v", i, err)
+		}
+		if !bytes.Equal(resp, want) {
+			t.Fatalf("request %d: got %x, want %x", i, resp, want)
+		}
+	}
+}
+
+func TestMultiplexer_ClientSilenceFramingIdleTimeout(t *testing.T) {
+	target, _ := modbusSerialTarget(t, 100*time.Millisecond)
+
+	mux := New(target, "1255", message.ModbusSilenceMessageReader{Silence: 50 * time.Millisecond}, 0, 2*time.Second, time.Second,
+		WithTargetReader(message.ModbusSerialMessageReader{}),
+		WithIdleTimeout(300*time.Millisecond))
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1255")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// the response to the first request arrives while the second frame is
+	// waiting for its silence, which must still end the frame before the
+	// third one starts
+	req := appendCRC([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01})
+	want := appendCRC([]byte{0x01, 0x03, 0x02, 0x00, 0x2A})
+	for _, pause := range []time.Duration{120 * time.Millisecond, 80 * time.Millisecond, 0} {
+		if _, err := conn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(pause)
+	}
+	for i := range 3 {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := message.ModbusSerialMessageReader{}.ReadResponse(conn)
+		if err != nil {
+			t.Fatalf("request %d: Expected no error, but got: %v", i, err)
+		}
+		if !bytes.Equal(resp, want) {
+			t.Fatalf("request %d: got %x, want %x", i, resp, want)
+		}
+	}
+
+	// an idle client is disconnected
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected the idle client to be disconnected, but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Expected the client to be disconnected after the idle timeout, but it took %s", elapsed)
+	}
+}
+
+func TestMultiplexer_QueueDuringReconnect(t *testing.T) {
+	// reserve a port for a target that is not up yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := l.Addr().String()
+	_ = l.Close()
+
+	mux := New(target, "1237", message.EchoMessageReader{}, 0, 2*time.Second, 20*time.Millisecond,
+		WithMaxRetryDelay(100*time.Millisecond),
+		WithQueueTimeout(3*time.Second))
+	startMultiplexer(t, &mux)
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		l, err := net.Listen("tcp", target)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		t.Cleanup(func() { _ = l.Close() })
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		handleConnection(conn)
+	}()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1237")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	echo := []byte("waiting for target\n")
+	if _, err := conn.Write(echo); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	reply, err := message.EchoMessageReader{}.ReadMessage(conn)
+	if err != nil {
+		t.Fatal("Expected the request to wait for the target, but got:", err)
+	}
+	if !bytes.Equal(reply, echo) {
+		t.Fatalf("Expected %s, but got %s", echo, reply)
+	}
+}
+
+func TestMultiplexer_FailWithoutQueueTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := l.Addr().String()
+	_ = l.Close()
+
+	mux := New(target, "1238", message.EchoMessageReader{}, 0, 2*time.Second, time.Second)
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1238")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.Write([]byte("no target\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := (message.EchoMessageReader{}).ReadMessage(conn); err != io.EOF {
+		t.Fatal("Expected the client connection to be closed, but got:", err)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if !strings.Contains(rec.Body.String(), `"circuit":"open"`) {
+		t.Errorf("Expected the status to report the open circuit, got %s", rec.Body.String())
+	}
+}
+
+// slowEchoTarget serves echo requests one at a time, answering each after
+// delay. It reports every request it receives.
+func slowEchoTarget(t *testing.T, delay time.Duration) (string, <-chan []byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	received := make(chan []byte, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			msg, err := message.EchoMessageReader{}.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			received <- msg
+			time.Sleep(delay)
+			if _, err := conn.Write(msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	return l.Addr().String(), received
+}
+
+func TestMultiplexer_DropExpiredRequest(t *testing.T) {
+	target, received := slowEchoTarget(t, 300*time.Millisecond)
+
+	mux := New(target, "1239", message.EchoMessageReader{}, 0, 2*time.Second, time.Second,
+		WithRequestTimeout(150*time.Millisecond))
+	startMultiplexer(t, &mux)
+
+	slow, err := net.Dial("tcp", "127.0.0.1:1239")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = slow.Close() }()
+	if _, err := slow.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	queued, err := net.Dial("tcp", "127.0.0.1:1239")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = queued.Close() }()
+	if _, err := queued.Write([]byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = slow.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := (message.EchoMessageReader{}).ReadMessage(slow); err != nil {
+		t.Fatal("Expected a reply to the first request, but got:", err)
+	}
+	_ = queued.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := (message.EchoMessageReader{}).ReadMessage(queued); err != io.EOF {
+		t.Fatal("Expected the queued client to be disconnected, but got:", err)
+	}
+
+	if msg := <-received; string(msg) != "first\n" {
+		t.Fatalf("target got %q, want first request", msg)
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("Expected the expired request to be dropped, but target got %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if expired := mux.Stats().Expired; expired != 1 {
+		t.Fatalf("Expected 1 expired request, got %d", expired)
+	}
+}
+
+func TestMultiplexer_SkipRequestOfDisconnectedClient(t *testing.T) {
+	target, received := slowEchoTarget(t, 300*time.Millisecond)
+
+	mux := New(target, "1240", message.EchoMessageReader{}, 0, 2*time.Second, time.Second)
+	startMultiplexer(t, &mux)
+
+	slow, err := net.Dial("tcp", "127.0.0.1:1240")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = slow.Close() }()
+	if _, err := slow.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// queue a write behind the first request and hang up
+	gone, err := net.Dial("tcp", "127.0.0.1:1240")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gone.Write([]byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = gone.Close()
+
+	_ = slow.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := (message.EchoMessageReader{}).ReadMessage(slow); err != nil {
+		t.Fatal("Expected a reply to the first request, but got:", err)
+	}
+
+	if msg := <-received; string(msg) != "first\n" {
+		t.Fatalf("target got %q, want first request", msg)
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("Expected the canceled request to be skipped, but target got %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if canceled := mux.Stats().Canceled; canceled != 1 {
+		t.Fatalf("Expected 1 canceled request, got %d", canceled)
+	}
+}
+
+func TestMultiplexer_PriorityClasses(t *testing.T) {
+	target, received := slowEchoTarget(t, 200*time.Millisecond)
+
+	ems, err := ParseClass("name=ems,priority=10,port=1242")
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	mux := New(target, "1241", message.EchoMessageReader{}, 0, 2*time.Second, time.Second,
+		WithExtraPorts("1242"), WithClasses([]Class{ems}, false), WithClientQueueLimit(3))
+	startMultiplexer(t, &mux)
+
+	dashboard, err := net.Dial("tcp", "127.0.0.1:1241")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = dashboard.Close() }()
+	// pipeline three requests, written separately because the echo reader
+	// does not keep bytes beyond the first line
+	for _, req := range []string{"d1\n", "d2\n", "d3\n"} {
+		if _, err := dashboard.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	controller, err := net.Dial("tcp", "127.0.0.1:1242")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = controller.Close() }()
+	if _, err := controller.Write([]byte("e1\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = controller.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if msg, err := (message.EchoMessageReader{}).ReadMessage(controller); err != nil || string(msg) != "e1\n" {
+		t.Fatalf("Expected a reply to the controller, but got %q, %v", msg, err)
+	}
+
+	// the dashboard gets its replies in order
+	_ = dashboard.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, want := range []string{"d1\n", "d2\n", "d3\n"} {
+		msg, err := (message.EchoMessageReader{}).ReadMessage(dashboard)
+		if err != nil || string(msg) != want {
+			t.Fatalf("Expected reply %q, but got %q, %v", want, msg, err)
+		}
+	}
+
+	// the controller's request overtook the queued dashboard requests
+	for _, want := range []string{"d1\n", "e1\n", "d2\n", "d3\n"} {
+		if msg := <-received; string(msg) != want {
+			t.Fatalf("target got %q, want %q", msg, want)
+		}
+	}
+}
+
+func TestMultiplexer_RateLimitDisconnect(t *testing.T) {
+	target, _ := slowEchoTarget(t, 0)
+
+	mux := New(target, "1243", message.EchoMessageReader{}, 0, 2*time.Second, time.Second,
+		WithRateLimits(RateLimit{Requests: 1}, RateLimit{}, RateLimitDisconnect))
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (message.EchoMessageReader{}).ReadMessage(conn); err != nil {
+		t.Fatal("Expected a reply to the first request, but got:", err)
+	}
+
+	if _, err := conn.Write([]byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := (message.EchoMessageReader{}).ReadMessage(conn); err == nil {
+		t.Fatalf("Expected the client to be disconnected, but got %q", msg)
+	}
+	if limited := mux.Stats().Limited; limited != 1 {
+		t.Fatalf("Expected 1 limited request, got %d", limited)
+	}
+}
+
+func TestMultiplexer_MaxClients(t *testing.T) {
+	target, _ := slowEchoTarget(t, 0)
+
+	mux := New(target, "1244", message.EchoMessageReader{}, 0, 2*time.Second, time.Second, WithMaxClients(1, 0))
+	startMultiplexer(t, &mux)
+
+	first, err := net.Dial("tcp", "127.0.0.1:1244")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = first.Close() }()
+	time.Sleep(50 * time.Millisecond)
+
+	second, err := net.Dial("tcp", "127.0.0.1:1244")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = second.Close() }()
+	_ = second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatal("Expected the second client to be rejected, but got:", err)
+	}
+	if rejected := mux.Stats().Rejected; rejected != 1 {
+		t.Fatalf("Expected 1 rejected connection, got %d", rejected)
+	}
+
+	_ = first.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := first.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (message.EchoMessageReader{}).ReadMessage(first); err != nil {
+		t.Fatal("Expected a reply to the first client, but got:", err)
+	}
+}
+
+func TestMultiplexer_SessionLease(t *testing.T) {
+	target, received := slowEchoTarget(t, 100*time.Millisecond)
+
+	mux := New(target, "1245", message.EchoMessageReader{}, 0, 2*time.Second, time.Second, WithSessionLease(2, time.Second))
+	startMultiplexer(t, &mux)
+
+	holder, err := net.Dial("tcp", "127.0.0.1:1245")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = holder.Close() }()
+	other, err := net.Dial("tcp", "127.0.0.1:1245")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = other.Close() }()
+
+	if _, err := holder.Write([]byte("read\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := other.Write([]byte("other\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the holder's second request is sent before the other client's request
+	// although it is queued later
+	_ = holder.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := (message.EchoMessageReader{}).ReadMessage(holder); err != nil {
+		t.Fatal("Expected a reply to the first request, but got:", err)
+	}
+	if _, err := holder.Write([]byte("write\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"read\n", "write\n", "other\n"} {
+		if msg := <-received; string(msg) != want {
+			t.Fatalf("target got %q, want %q", msg, want)
+		}
+	}
+}
+
+func TestMultiplexer_RawSession(t *testing.T) {
+	target, received := slowEchoTarget(t, 0)
+
+	mux := New(target, "1246", message.RawMessageReader{Gap: 200 * time.Millisecond}, 0, 2*time.Second, time.Second)
+	startMultiplexer(t, &mux)
+
+	first, err := net.Dial("tcp", "127.0.0.1:1246")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = first.Close() }()
+	second, err := net.Dial("tcp", "127.0.0.1:1246")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = second.Close() }()
+
+	if _, err := first.Write([]byte("a1\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := second.Write([]byte("b1\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	// still within the first client's session
+	if _, err := first.Write([]byte("a2\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"a1\n", "a2\n", "b1\n"} {
+		select {
+		case msg := <-received:
+			if string(msg) != want {
+				t.Fatalf("target got %q, want %q", msg, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected target to receive %q", want)
+		}
+	}
+
+	_ = second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if msg, err := (message.EchoMessageReader{}).ReadMessage(second); err != nil || string(msg) != "b1\n" {
+		t.Fatalf("Expected the second client to get its bytes back, but got %q, %v", msg, err)
+	}
+}
+
+// progressReader expects progress lines in response to a request, up to a
+// line starting with "done".
+type progressReader struct {
+	message.SCPIMessageReader
+}
+
+func (progressReader) Responses([]byte) message.Responses {
+	return message.UntilFinal
+}
+
+func (progressReader) IsFinal(req, resp []byte) bool {
+	return bytes.HasPrefix(resp, []byte("done"))
+}
+
+// lineTarget starts a target server that records request lines and answers
+// them with reply, if it returns one.
+func lineTarget(t *testing.T, reply func(req string) string) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	received := make(chan string, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			req, err := message.SCPIMessageReader{}.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			received <- string(req)
+			if resp := reply(string(req)); resp != "" {
+				if _, err := conn.Write([]byte(resp)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return l.Addr().String(), received
+}
+
+func TestMultiplexer_NoResponse(t *testing.T) {
+	target, received := lineTarget(t, func(req string) string {
+		if req == "*IDN?\n" {
+			return "ACME,PSU\n"
+		}
+		return ""
+	})
+
+	// the turnaround delay only applies to broadcasts
+	mux := New(target, "1247", message.SCPIMessageReader{}, 0, 2*time.Second, time.Second, WithTurnaroundDelay(time.Second))
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1247")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	start := time.Now()
+	if _, err := conn.Write([]byte("VOLT 5\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("*IDN?\n")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := message.SCPIMessageReader{}.ReadMessage(conn)
+	if err != nil || string(msg) != "ACME,PSU\n" {
+		t.Fatalf("Expected the reply to the query, but got %q, %v", msg, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the query to follow the command right away, but it took %s", elapsed)
+	}
+	for _, want := range []string{"VOLT 5\n", "*IDN?\n"} {
+		if req := <-received; req != want {
+			t.Fatalf("target got %q, want %q", req, want)
+		}
+	}
+}
+
+func TestMultiplexer_MultipleResponseFrames(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	// the target holds back the final frame until the test has received the
+	// progress frames
+	release := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			if _, err := (message.SCPIMessageReader{}).ReadMessage(conn); err != nil {
+				return
+			}
+			if _, err := conn.Write([]byte("progress 50%\nprogress 100%\n")); err != nil {
+				return
+			}
+			<-release
+			if _, err := conn.Write([]byte("done\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	mux := New(l.Addr().String(), "1248", progressReader{}, 0, 2*time.Second, time.Second, WithClientQueueLimit(2))
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1248")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	// both requests are in flight while the frames of the first arrive
+	if _, err := conn.Write([]byte("START\nSTART\n")); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		for _, want := range []string{"progress 50%\n", "progress 100%\n", "done\n"} {
+			if want == "done\n" {
+				release <- struct{}{}
+			}
+			msg, err := message.SCPIMessageReader{}.ReadMessage(conn)
+			if err != nil || string(msg) != want {
+				t.Fatalf("Expected %q, but got %q, %v", want, msg, err)
+			}
+		}
+	}
+}
+
+func TestMultiplexer_UnsolicitedBroadcast(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	// the target announces an event before it answers each request
+	event := []byte{0xFF, 0xFF, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x01}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			req, err := message.ModbusMessageReader{}.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			resp := append(append([]byte{}, req[:4]...), 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2A)
+			if _, err := conn.Write(append(append([]byte{}, event...), resp...)); err != nil {
+				return
+			}
+		}
+	}()
+
+	mux := New(l.Addr().String(), "1249", message.ModbusMessageReader{}, 0, 2*time.Second, time.Second,
+		WithUnsolicited(UnsolicitedRoute{Action: UnsolicitedBroadcast}))
+	startMultiplexer(t, &mux)
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", "127.0.0.1:1249")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return conn
+	}
+	idle := dial()
+	active := dial()
+	time.Sleep(50 * time.Millisecond)
+
+	req := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	if _, err := active.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2A}
+	gotResponse, gotEvent := false, false
+	for range 2 {
+		msg, err := message.ModbusMessageReader{}.ReadMessage(active)
+		if err != nil {
+			t.Fatal("Expected no error, but got:", err)
+		}
+		switch {
+		case bytes.Equal(msg, want):
+			gotResponse = true
+		case bytes.Equal(msg, event):
+			gotEvent = true
+		default:
+			t.Fatalf("unexpected frame %x", msg)
+		}
+	}
+	if !gotResponse || !gotEvent {
+		t.Fatalf("Expected the response and the event, got response %v, event %v", gotResponse, gotEvent)
+	}
+
+	msg, err := message.ModbusMessageReader{}.ReadMessage(idle)
+	if err != nil || !bytes.Equal(msg, event) {
+		t.Fatalf("Expected the event on the idle client, but got %x, %v", msg, err)
+	}
+}
+
+func TestMultiplexer_ISO8583Correlation(t *testing.T) {
+	pack := func(mti, stan string) []byte {
+		msg, err := message.DefaultISO8583Spec.Pack(&message.ISO8583Message{MTI: mti, Fields: map[int]string{11: stan}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append([]byte{0, byte(len(msg))}, msg...)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	// the host sends an echo test of its own before it answers
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			req, err := message.ISO8583MessageReader{}.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			m, err := message.ISO8583MessageReader{}.Decode(req)
+			if err != nil {
+				return
+			}
+			resp := append(pack("0800", "999999"), pack(m.ResponseMTI(), m.STAN())...)
+			if _, err := conn.Write(resp); err != nil {
+				return
+			}
+		}
+	}()
+
+	mux := New(l.Addr().String(), "1250", message.ISO8583MessageReader{}, 0, 2*time.Second, time.Second,
+		WithUnsolicited(UnsolicitedRoute{Action: UnsolicitedDrop}))
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1250")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	for _, stan := range []string{"000001", "000002"} {
+		if _, err := conn.Write(pack("0200", stan)); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := message.ISO8583MessageReader{}.ReadMessage(conn)
+		if err != nil || !bytes.Equal(msg, pack("0210", stan)) {
+			t.Fatalf("Expected the 0210 for STAN %s, but got %x, %v", stan, msg, err)
+		}
+	}
+}
+
+func TestMultiplexer_ISO8583SessionLease(t *testing.T) {
+	target, requests := iso8583Host(t, "00")
+
+	reader := message.ISO8583MessageReader{Session: &message.ISO8583Session{MTIs: []string{"01"}}}
+	mux := New(target, "1256", reader, 0, 2*time.Second, time.Second, WithSessionLease(0, time.Second))
+	startMultiplexer(t, &mux)
+
+	holder, err := net.Dial("tcp", "127.0.0.1:1256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = holder.Close() }()
+	other, err := net.Dial("tcp", "127.0.0.1:1256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = other.Close() }()
+
+	exchange := func(conn net.Conn, mti, stan string) {
+		t.Helper()
+		req, err := reader.Encode(&message.ISO8583Message{MTI: mti, Fields: map[int]string{11: stan}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the authorization holds the session until its reversal
+	exchange(holder, "0100", "000001")
+	_ = holder.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := reader.ReadMessage(holder); err != nil {
+		t.Fatal("Expected a reply to the authorization, but got:", err)
+	}
+	exchange(other, "0200", "000002")
+	time.Sleep(50 * time.Millisecond)
+	exchange(holder, "0420", "000003")
+
+	for _, want := range []string{"0100/", "0420/", "0200/"} {
+		select {
+		case got := <-requests:
+			if got != want {
+				t.Fatalf("target got %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("target did not get %q", want)
+		}
+	}
+}
+
+func TestMultiplexer_ISO8583DiscardMismatch(t *testing.T) {
+	pack := func(mti, stan string) []byte {
+		msg, err := message.DefaultISO8583Spec.Pack(&message.ISO8583Message{MTI: mti, Fields: map[int]string{11: stan}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append([]byte{0, byte(len(msg))}, msg...)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	// the host sends a late response to an earlier transaction before it
+	// answers, and never answers STAN 000002
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				for {
+					req, err := message.ISO8583MessageReader{}.ReadMessage(conn)
+					if err != nil {
+						return
+					}
+					m, err := message.ISO8583MessageReader{}.Decode(req)
+					if err != nil {
+						return
+					}
+					resp := pack("0210", "999999")
+					if m.STAN() != "000002" {
+						resp = append(resp, pack(m.ResponseMTI(), m.STAN())...)
+					}
+					if _, err := conn.Write(resp); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	mux := New(l.Addr().String(), "1257", message.ISO8583MessageReader{}, 0, 300*time.Millisecond, time.Second)
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1257")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write(pack("0200", "000001")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := message.ISO8583MessageReader{}.ReadMessage(conn)
+	if err != nil || !bytes.Equal(msg, pack("0210", "000001")) {
+		t.Fatalf("Expected the 0210 for STAN 000001, but got %x, %v", msg, err)
+	}
+
+	// the response to another transaction is not delivered instead
+	if _, err := conn.Write(pack("0200", "000002")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err = message.ISO8583MessageReader{}.ReadMessage(conn)
+	if err == nil {
+		t.Fatalf("Expected the connection to be closed, but got %x", msg)
+	}
+}
+
+// iso8583Host is an ISO 8583 host that approves network management requests
+// and authorizations, and sends an echo test of its own after the sign-on.
+// It passes the network management code or MTI of every request to requests.
+func iso8583Host(t *testing.T, signOnCode string) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	r := message.ISO8583MessageReader{}
+	requests := make(chan string, 20)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			frame, err := r.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			m, err := r.Decode(frame)
+			if err != nil {
+				return
+			}
+			if !m.IsRequest() {
+				requests <- m.MTI
+				continue
+			}
+			requests <- m.MTI + "/" + m.Fields[70]
+
+			resp := &message.ISO8583Message{MTI: m.ResponseMTI(), Fields: map[int]string{11: m.STAN(), 39: "00"}}
+			if m.Fields[70] == "001" && signOnCode != "00" {
+				resp.Fields[39] = signOnCode
+			}
+			b, err := r.Encode(resp)
+			if err != nil {
+				return
+			}
+			if m.Fields[70] == "001" {
+				echo, err := r.Encode(&message.ISO8583Message{MTI: "0800", Fields: map[int]string{11: "900001", 70: "301"}})
+				if err != nil {
+					return
+				}
+				b = append(b, echo...)
+			}
+			if _, err := conn.Write(b); err != nil {
+				return
+			}
+		}
+	}()
+	return l.Addr().String(), requests
+}
+
+func TestMultiplexer_ISO8583NetworkManagement(t *testing.T) {
+	target, requests := iso8583Host(t, "00")
+
+	r := message.ISO8583MessageReader{Network: &message.ISO8583Network{SignOn: "001", EchoTest: "301", EchoInterval: 200 * time.Millisecond}}
+	// the delay is replaced by the sign-on
+	mux := New(target, "1251", r, time.Hour, 2*time.Second, time.Second,
+		WithUnsolicited(UnsolicitedRoute{Action: UnsolicitedAnswer}))
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1251")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	req, err := r.Encode(&message.ISO8583Message{MTI: "0200", Fields: map[int]string{11: "000042"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := r.ReadMessage(conn)
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	if m, err := r.Decode(resp); err != nil || m.MTI != "0210" || m.STAN() != "000042" {
+		t.Fatalf("Expected the 0210, but got %+v, %v", m, err)
+	}
+
+	// sign-on, the answer to the host's echo test in any order with the
+	// authorization, then an echo test of the idle link
+	var got []string
+	for len(got) < 4 {
+		select {
+		case r := <-requests:
+			got = append(got, r)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected sign-on, authorization, answer and echo test, but got %v", got)
+		}
+	}
+	if got[0] != "0800/001" || !slices.Contains(got, "0200/") || !slices.Contains(got, "0810") || got[3] != "0800/301" {
+		t.Errorf("unexpected requests %v", got)
+	}
+}
+
+func TestMultiplexer_ISO8583SignOnDeclined(t *testing.T) {
+	target, requests := iso8583Host(t, "91")
+
+	r := message.ISO8583MessageReader{Network: &message.ISO8583Network{SignOn: "001"}}
+	mux := New(target, "1252", r, 0, 2*time.Second, time.Second)
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1252")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	req, err := r.Encode(&message.ISO8583Message{MTI: "0200", Fields: map[int]string{11: "000042"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	// the request fails like with a target that cannot be reached
+	if _, err := r.ReadMessage(conn); err == nil {
+		t.Fatal("Expected the client to be disconnected")
+	}
+	if got := <-requests; got != "0800/001" {
+		t.Errorf("Expected the sign-on, got %s", got)
+	}
+	if s := mux.Stats(); s.Circuit != CircuitOpen {
+		t.Errorf("Expected the circuit to be open, got %v", s.Circuit)
+	}
+}
+
+func TestMultiplexer_RateLimitDelay(t *testing.T) {
+	target, _ := slowEchoTarget(t, 0)
+
+	mux := New(target, "1254", message.EchoMessageReader{}, 0, 2*time.Second, time.Second,
+		WithClientQueueLimit(2),
+		WithRateLimits(RateLimit{Requests: 1}, RateLimit{}, RateLimitDelay))
+	startMultiplexer(t, &mux)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1254")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	start := time.Now()
+	// written separately because the echo reader does not keep bytes beyond
+	// the first line
+	for _, req := range []string{"A\n", "B\n"} {
+		if _, err := conn.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	msg, err := message.EchoMessageReader{}.ReadMessage(conn)
+	if err != nil || string(msg) != "A\n" {
+		t.Fatalf("Expected the reply to the first request, but got %q, %v", msg, err)
+	}
+	// the delayed second request does not hold back the first reply
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the first reply right away, but it took %s", elapsed)
+	}
+
+	msg, err = message.EchoMessageReader{}.ReadMessage(conn)
+	if err != nil || string(msg) != "B\n" {
+		t.Fatalf("Expected the reply to the second request, but got %q, %v", msg, err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("Expected the second request to be delayed by the rate limit, but it took %s", elapsed)
+	}
+	if limited := mux.Stats().Limited; limited != 1 {
+		t.Errorf("Expected 1 limited request, got %d", limited)
+	}
+}
@@ -0,0 +1,59 @@
+package multiplexer
+
+import (
+	"log/slog"
+	"time"
+)
+
+// pacer spaces out the requests sent to the target server for devices that
+// cannot keep up with back-to-back requests.
+type pacer struct {
+	// gap is the minimum time between a response from any unit and the next
+	// request, e.g. the turnaround time of a bus.
+	gap time.Duration
+	// unitGaps is the minimum time between a response from a specific unit ID
+	// and the next request to the same unit.
+	unitGaps map[byte]time.Duration
+	// interval is the minimum time between the starts of two requests.
+	interval time.Duration
+
+	lastRequest  time.Time
+	lastResponse time.Time
+	// unitResponses holds the time of the last response per unit ID.
+	unitResponses map[byte]time.Time
+}
+
+// wait blocks until the next request may be sent. unit is the unit ID the
+// request is addressed to, if the protocol has one.
+func (p *pacer) wait(unit byte, hasUnit bool) {
+	next := p.lastResponse.Add(p.gap)
+	if n := p.lastRequest.Add(p.interval); n.After(next) {
+		next = n
+	}
+	if g, ok := p.unitGaps[unit]; ok && hasUnit {
+		if n := p.unitResponses[unit].Add(g); n.After(next) {
+			next = n
+		}
+	}
+
+	if d := time.Until(next); d > 0 {
+		slog.Debug("delaying request to target", "delay", d)
+		time.Sleep(d)
+	}
+}
+
+// sent records that a request has been sent.
+func (p *pacer) sent() {
+	p.lastRequest = time.Now()
+}
+
+// received records that the exchange with the target has ended.
+func (p *pacer) received(unit byte, hasUnit bool) {
+	p.lastResponse = time.Now()
+	if hasUnit {
+		if p.unitResponses == nil {
+			p.unitResponses = make(map[byte]time.Time)
+		}
+		p.unitResponses[unit] = p.lastResponse
+	}
+}
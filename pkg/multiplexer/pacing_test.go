@@ -0,0 +1,42 @@
+package multiplexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacer(t *testing.T) {
+	gaps := &pacer{
+		gap:      20 * time.Millisecond,
+		unitGaps: map[byte]time.Duration{2: 60 * time.Millisecond},
+	}
+	rate := &pacer{interval: 40 * time.Millisecond}
+
+	tests := []struct {
+		name    string
+		pacer   *pacer
+		unit    byte
+		hasUnit bool
+		min     time.Duration
+	}{
+		{name: "first request", pacer: gaps, unit: 2, hasUnit: true, min: 0},
+		{name: "gap", pacer: gaps, unit: 1, hasUnit: true, min: 20 * time.Millisecond},
+		// the unit gap runs from the last response of unit 2, 20ms ago
+		{name: "unit gap", pacer: gaps, unit: 2, hasUnit: true, min: 40 * time.Millisecond},
+		{name: "no unit", pacer: gaps, unit: 2, hasUnit: false, min: 20 * time.Millisecond},
+		{name: "first request at max rate", pacer: rate, min: 0},
+		{name: "max rate", pacer: rate, min: 40 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			tt.pacer.wait(tt.unit, tt.hasUnit)
+			if d := time.Since(start); d < tt.min-time.Millisecond || d > tt.min+30*time.Millisecond {
+				t.Errorf("wait() took %v, want %v", d, tt.min)
+			}
+			tt.pacer.sent()
+			tt.pacer.received(tt.unit, tt.hasUnit)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package multiplexer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the requests and request bytes per second a client may
+// send. Zero values mean unlimited.
+type RateLimit struct {
+	Requests float64
+	Bytes    float64
+}
+
+func (r RateLimit) enabled() bool {
+	return r.Requests > 0 || r.Bytes > 0
+}
+
+// RateLimitAction is what the multiplexer does with a request that exceeds a
+// rate limit.
+type RateLimitAction int
+
+const (
+	// RateLimitDelay holds the request back until the limit allows it.
+	RateLimitDelay RateLimitAction = iota
+	// RateLimitBusy answers the request with a protocol specific busy error.
+	RateLimitBusy
+	// RateLimitDisconnect disconnects the client.
+	RateLimitDisconnect
+)
+
+func (a RateLimitAction) String() string {
+	switch a {
+	case RateLimitDelay:
+		return "delay"
+	case RateLimitBusy:
+		return "busy"
+	case RateLimitDisconnect:
+		return "disconnect"
+	}
+	return fmt.Sprintf("RateLimitAction(%d)", int(a))
+}
+
+// ParseRateLimitAction parses delay, busy or disconnect.
+func ParseRateLimitAction(s string) (RateLimitAction, error) {
+	for _, a := range []RateLimitAction{RateLimitDelay, RateLimitBusy, RateLimitDisconnect} {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown rate limit action %q", s)
+}
+
+// tokenBucket refills rate tokens per second up to a burst of one second's
+// worth of tokens, but at least one.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: max(rate, 1), last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, max(b.rate, 1))
+	b.last = now
+}
+
+// available reports whether n tokens can be taken without waiting. Requests
+// larger than the burst are let through once the bucket is full.
+func (b *tokenBucket) available(n float64) bool {
+	return b.tokens >= min(n, max(b.rate, 1))
+}
+
+// take removes n tokens, possibly going into debt, and returns how long the
+// caller has to wait until the debt has been paid back.
+func (b *tokenBucket) take(n float64) time.Duration {
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// limits are the buckets of a client or a source IP.
+type limits struct {
+	requests *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newLimits(r RateLimit) limits {
+	return limits{requests: newTokenBucket(r.Requests), bytes: newTokenBucket(r.Bytes)}
+}
+
+// ipLimits are the buckets shared by all clients from one source IP.
+type ipLimits struct {
+	limits
+	clients int
+}
+
+// rateLimiter enforces the rate limits per client and per source IP.
+type rateLimiter struct {
+	client RateLimit
+	ip     RateLimit
+	action RateLimitAction
+
+	mu  sync.Mutex
+	ips map[string]*ipLimits
+}
+
+// clientLimiter enforces the rate limits of one client.
+type clientLimiter struct {
+	l    *rateLimiter
+	addr string
+	own  limits
+	ip   *ipLimits
+}
+
+// join returns the limiter for a client connecting from addr. It must be
+// released with leave once the client disconnects.
+func (l *rateLimiter) join(addr string) *clientLimiter {
+	c := &clientLimiter{l: l, addr: addr, own: newLimits(l.client)}
+	if !l.ip.enabled() {
+		return c
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ips == nil {
+		l.ips = make(map[string]*ipLimits)
+	}
+	ip, ok := l.ips[addr]
+	if !ok {
+		ip = &ipLimits{limits: newLimits(l.ip)}
+		l.ips[addr] = ip
+	}
+	ip.clients++
+	c.ip = ip
+	return c
+}
+
+func (c *clientLimiter) leave() {
+	if c.ip == nil {
+		return
+	}
+	c.l.mu.Lock()
+	defer c.l.mu.Unlock()
+	c.ip.clients--
+	if c.ip.clients == 0 {
+		delete(c.l.ips, c.addr)
+	}
+}
+
+// buckets returns the buckets a request of n bytes has to pass with the
+// number of tokens it takes from each.
+func (c *clientLimiter) buckets(n int) map[*tokenBucket]float64 {
+	buckets := make(map[*tokenBucket]float64, 4)
+	add := func(l limits) {
+		if l.requests != nil {
+			buckets[l.requests] = 1
+		}
+		if l.bytes != nil {
+			buckets[l.bytes] = float64(n)
+		}
+	}
+	add(c.own)
+	if c.ip != nil {
+		add(c.ip.limits)
+	}
+	return buckets
+}
+
+// reserve takes the tokens for a request of n bytes and returns how long the
+// request has to be delayed to stay within the limits.
+func (c *clientLimiter) reserve(n int) time.Duration {
+	c.l.mu.Lock()
+	defer c.l.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	for b, tokens := range c.buckets(n) {
+		b.refill(now)
+		wait = max(wait, b.take(tokens))
+	}
+	return wait
+}
+
+// allow takes the tokens for a request of n bytes if it is within all limits.
+func (c *clientLimiter) allow(n int) bool {
+	c.l.mu.Lock()
+	defer c.l.mu.Unlock()
+	now := time.Now()
+	buckets := c.buckets(n)
+	for b, tokens := range buckets {
+		b.refill(now)
+		if !b.available(tokens) {
+			return false
+		}
+	}
+	for b, tokens := range buckets {
+		b.take(tokens)
+	}
+	return true
+}
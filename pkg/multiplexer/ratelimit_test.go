@@ -0,0 +1,72 @@
+package multiplexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientLimiter_Allow(t *testing.T) {
+	l := &rateLimiter{client: RateLimit{Requests: 2}, ip: RateLimit{Bytes: 10}}
+	a := l.join("10.0.0.1")
+	b := l.join("10.0.0.1")
+	other := l.join("10.0.0.2")
+
+	tests := []struct {
+		name    string
+		limiter *clientLimiter
+		n       int
+		want    bool
+	}{
+		{name: "first request", limiter: a, n: 4, want: true},
+		{name: "second request", limiter: a, n: 4, want: true},
+		{name: "client request limit", limiter: a, n: 1, want: false},
+		{name: "other client from same IP", limiter: b, n: 2, want: true},
+		{name: "IP byte limit", limiter: b, n: 2, want: false},
+		{name: "client from other IP", limiter: other, n: 10, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limiter.allow(tt.n); got != tt.want {
+				t.Errorf("allow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	a.leave()
+	b.leave()
+	if _, ok := l.ips["10.0.0.1"]; ok {
+		t.Error("Expected the IP limits to be removed with the last client")
+	}
+}
+
+func TestClientLimiter_Reserve(t *testing.T) {
+	l := &rateLimiter{client: RateLimit{Requests: 10}}
+	c := l.join("10.0.0.1")
+	defer c.leave()
+
+	// the burst of one second passes without delay
+	for range 10 {
+		if wait := c.reserve(1); wait != 0 {
+			t.Fatalf("Expected no delay within the burst, but got %v", wait)
+		}
+	}
+	if wait := c.reserve(1); wait < 90*time.Millisecond || wait > 100*time.Millisecond {
+		t.Fatalf("Expected a delay of 100ms, but got %v", wait)
+	}
+	if wait := c.reserve(1); wait < 190*time.Millisecond || wait > 200*time.Millisecond {
+		t.Fatalf("Expected a delay of 200ms, but got %v", wait)
+	}
+}
+
+func TestParseRateLimitAction(t *testing.T) {
+	for _, want := range []RateLimitAction{RateLimitDelay, RateLimitBusy, RateLimitDisconnect} {
+		got, err := ParseRateLimitAction(want.String())
+		if err != nil || got != want {
+			t.Errorf("ParseRateLimitAction(%q) = %v, %v, want %v", want.String(), got, err, want)
+		}
+	}
+	if _, err := ParseRateLimitAction("drop"); err == nil {
+		t.Error("Expected an error for an unknown action")
+	}
+}
@@ -0,0 +1,200 @@
+package multiplexer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+)
+
+// rawSession hands the target connection to a client of a protocol without
+// message boundaries.
+type rawSession struct {
+	// granted receives the target connection once it is the client's turn.
+	granted chan targetConn
+	// released receives the error of the target connection, if any, once
+	// the client is done with it.
+	released chan error
+}
+
+// handleRawConnection pipes bytes between a client and the target server. The
+// client waits in the queue with its first bytes and then has the target
+// connection for itself until no bytes have been sent in either direction for
+// the idle gap of the protocol.
+func (mux *Multiplexer) handleRawConnection(conn net.Conn, sender chan<- *reqContainer, client *clientConn, gap time.Duration) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	requests := make(chan []byte)
+
+	defer func(c net.Conn) {
+		slog.Debug("closing client connection", "remote", c.RemoteAddr())
+		close(client.done)
+		cancel(errClientDisconnected)
+		err := c.Close()
+		sender <- &reqContainer{typ: Disconnection, client: client}
+		if err != nil {
+			slog.Error("error closing client connection", "error", err)
+		}
+	}(conn)
+
+	sender <- &reqContainer{typ: Connection, client: client}
+
+	go mux.readRequests(conn, client, requests, cancel)
+
+	for {
+		// the client is busy from its first request until the session ends
+		client.setBusy(false)
+		msg, ok := <-requests
+		if !ok {
+			return
+		}
+
+		session := &rawSession{granted: make(chan targetConn), released: make(chan error, 1)}
+		callback := make(chan *respContainer, 1)
+		sender <- &reqContainer{
+			typ:      Packet,
+			message:  msg,
+			sender:   callback,
+			deadline: time.Now().Add(mux.queueTimeout),
+			ctx:      ctx,
+			client:   client,
+			session:  session,
+		}
+
+		// keep reading while waiting for the target, so that a client that
+		// disconnects gives up its place in the queue
+		pending := [][]byte{msg}
+		var target targetConn
+		for target == nil {
+			select {
+			case target = <-session.granted:
+			case resp := <-callback:
+				slog.Error("failed to forward message", "error", resp.err)
+				return
+			case msg, ok := <-requests:
+				if !ok {
+					return
+				}
+				pending = append(pending, msg)
+			}
+		}
+
+		slog.Debug("raw session started", "id", client.id)
+		open := mux.pipe(conn, target, requests, pending, gap, session)
+		slog.Debug("raw session ended", "id", client.id)
+		if !open {
+			return
+		}
+	}
+}
+
+// pipe copies bytes between the client and the target connection until no
+// bytes have been sent for gap, and then releases the target connection. It
+// reports whether the client is still connected.
+func (mux *Multiplexer) pipe(conn net.Conn, target targetConn, requests <-chan []byte, pending [][]byte, gap time.Duration, session *rawSession) bool {
+	// the target connection may still have the deadline of a previous request
+	if err := target.SetReadDeadline(time.Time{}); err != nil {
+		slog.Error("error setting read deadline", "error", err)
+	}
+
+	activity := make(chan struct{}, 1)
+	targetDone := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := target.Read(buf)
+			if n > 0 {
+				select {
+				case activity <- struct{}{}:
+				default:
+				}
+				slog.Debug("raw bytes from target", "frame", mux.logTarget(buf[:n], false))
+				_ = conn.SetWriteDeadline(mux.deadline())
+				if _, err := conn.Write(buf[:n]); err != nil {
+					slog.Error("error writing to client", "error", err)
+				}
+			}
+			if err != nil {
+				targetDone <- err
+				return
+			}
+		}
+	}()
+
+	var targetErr error
+	write := func(msg []byte) {
+		slog.Debug("raw bytes from client", "frame", mux.logClient(msg))
+		if err := target.SetWriteDeadline(time.Now().Add(mux.responseTimeout)); err != nil {
+			slog.Error("error setting write deadline", "error", err)
+		}
+		if _, err := target.Write(msg); err != nil {
+			targetErr = err
+		}
+	}
+	for _, msg := range pending {
+		write(msg)
+	}
+
+	clientOpen := true
+	readerDone := false
+	idle := time.NewTimer(gap)
+	defer idle.Stop()
+loop:
+	for targetErr == nil {
+		select {
+		case msg, ok := <-requests:
+			if !ok {
+				clientOpen = false
+				break loop
+			}
+			write(msg)
+			idle.Reset(gap)
+		case <-activity:
+			idle.Reset(gap)
+		case targetErr = <-targetDone:
+			readerDone = true
+		case <-idle.C:
+			break loop
+		}
+	}
+
+	if !readerDone {
+		// stop the reader and keep the target connection for the next client
+		if err := target.SetReadDeadline(time.Now()); err != nil {
+			slog.Error("error setting read deadline", "error", err)
+		}
+		if err := <-targetDone; !errors.Is(err, os.ErrDeadlineExceeded) && targetErr == nil {
+			targetErr = err
+		}
+		if err := target.SetReadDeadline(time.Time{}); err != nil {
+			slog.Error("error setting read deadline", "error", err)
+		}
+	}
+	session.released <- targetErr
+	return clientOpen
+}
+
+// lend gives the target connection to the client of a raw session and waits
+// until the client releases it. It returns false if the client has gone.
+func (mux *Multiplexer) lend(conn targetConn, container *reqContainer) (bool, error) {
+	select {
+	case container.session.granted <- conn:
+	case <-container.ctx.Done():
+		mux.drop(container)
+		return false, nil
+	}
+	return true, <-container.session.released
+}
+
+// streamer returns the idle gap of the clients' protocol if it has no message
+// boundaries.
+func (mux *Multiplexer) streamer() (time.Duration, bool) {
+	s, ok := mux.messageReader.(message.Streamer)
+	if !ok {
+		return 0, false
+	}
+	return s.IdleGap(), true
+}
@@ -0,0 +1,57 @@
+package multiplexer
+
+import "sync"
+
+// relay passes the response frames of a request to the client's connection
+// handler in order. Unlike a send on the request's callback, it never blocks
+// the target conn loop, which would deadlock while the handler itself waits to
+// enqueue its next request.
+type relay struct {
+	mu      sync.Mutex
+	pending []*respContainer
+	wake    chan struct{}
+}
+
+// newRelay starts relaying to sender until a frame without more set has been
+// passed on or done is closed.
+func newRelay(sender chan<- *respContainer, done <-chan struct{}) *relay {
+	r := &relay{wake: make(chan struct{}, 1)}
+	go r.run(sender, done)
+	return r
+}
+
+// send queues resp to be passed on.
+func (r *relay) send(resp *respContainer) {
+	r.mu.Lock()
+	r.pending = append(r.pending, resp)
+	r.mu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *relay) run(sender chan<- *respContainer, done <-chan struct{}) {
+	for {
+		select {
+		case <-r.wake:
+		case <-done:
+			return
+		}
+		r.mu.Lock()
+		pending := r.pending
+		r.pending = nil
+		r.mu.Unlock()
+
+		for _, resp := range pending {
+			select {
+			case sender <- resp:
+			case <-done:
+				return
+			}
+			if !resp.more {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package multiplexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	callback := make(chan *respContainer, 1)
+	r := newRelay(callback, make(chan struct{}))
+
+	// sending never blocks, even while nobody receives
+	for _, msg := range []string{"a", "b", "c"} {
+		r.send(&respContainer{message: []byte(msg), more: true})
+	}
+	r.send(&respContainer{message: []byte("done")})
+
+	for _, want := range []string{"a", "b", "c", "done"} {
+		select {
+		case resp := <-callback:
+			if string(resp.message) != want {
+				t.Fatalf("got %q, want %q", resp.message, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %q to be relayed", want)
+		}
+	}
+}
+
+func TestRelay_ClientGone(t *testing.T) {
+	callback := make(chan *respContainer)
+	done := make(chan struct{})
+	r := newRelay(callback, done)
+	r.send(&respContainer{message: []byte("a"), more: true})
+	close(done)
+	r.send(&respContainer{message: []byte("done")})
+	// let the relay notice before anyone receives
+	time.Sleep(20 * time.Millisecond)
+
+	select {
+	case resp := <-callback:
+		t.Fatalf("Expected nothing to be relayed to a gone client, got %q", resp.message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
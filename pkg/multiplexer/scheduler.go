@@ -0,0 +1,185 @@
+package multiplexer
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Class is a priority class that clients are assigned to when they connect.
+type Class struct {
+	Name string
+	// Priority orders classes with strict priority scheduling: requests of
+	// clients in classes with a higher priority are always sent first.
+	Priority int
+	// Weight is the share of the target a client in this class gets relative
+	// to other clients with weighted fair queuing. Weights below 1 count as
+	// 1, so that the class is not starved.
+	Weight int
+	// Networks matches clients by source address.
+	Networks []*net.IPNet
+	// Ports matches clients by the port they connected to.
+	Ports []string
+}
+
+// defaultClass is assigned to clients that match no configured class.
+var defaultClass = &Class{Name: "default", Weight: 1}
+
+// ParseClass parses a class given as comma separated key=value pairs, e.g.
+// "name=ems,priority=10,weight=4,cidr=10.0.0.5/32,port=5021". The cidr and
+// port keys may be repeated.
+func ParseClass(s string) (Class, error) {
+	class := Class{Weight: 1}
+	for field := range strings.SplitSeq(s, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Class{}, fmt.Errorf("invalid class field %q", field)
+		}
+		var err error
+		switch key {
+		case "name":
+			class.Name = value
+		case "priority":
+			class.Priority, err = strconv.Atoi(value)
+		case "weight":
+			class.Weight, err = strconv.Atoi(value)
+			if err == nil && class.Weight < 1 {
+				err = fmt.Errorf("weight must be positive")
+			}
+		case "cidr":
+			var network *net.IPNet
+			_, network, err = net.ParseCIDR(value)
+			class.Networks = append(class.Networks, network)
+		case "port":
+			class.Ports = append(class.Ports, value)
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+		if err != nil {
+			return Class{}, fmt.Errorf("invalid class field %q: %w", field, err)
+		}
+	}
+	if class.Name == "" {
+		return Class{}, fmt.Errorf("class %q has no name", s)
+	}
+	return class, nil
+}
+
+// matches reports whether a client connected from remote to local belongs to
+// the class. All given criteria have to match.
+func (c *Class) matches(local, remote net.Addr) bool {
+	if len(c.Networks) > 0 {
+		addr, ok := remote.(*net.TCPAddr)
+		if !ok || !slices.ContainsFunc(c.Networks, func(n *net.IPNet) bool { return n.Contains(addr.IP) }) {
+			return false
+		}
+	}
+	if len(c.Ports) > 0 {
+		addr, ok := local.(*net.TCPAddr)
+		if !ok || !slices.Contains(c.Ports, strconv.Itoa(addr.Port)) {
+			return false
+		}
+	}
+	return true
+}
+
+// classify returns the first class matching a client connection.
+func classify(classes []Class, local, remote net.Addr) *Class {
+	for i := range classes {
+		if classes[i].matches(local, remote) {
+			return &classes[i]
+		}
+	}
+	return defaultClass
+}
+
+// scheduler orders the requests waiting for the target server, either by
+// strict priority of their clients' classes (and arrival within a class) or
+// by weighted fair queuing across clients.
+type scheduler struct {
+	wfq bool
+	seq uint64
+	// virtualTime is the finish tag of the last request taken off the queue.
+	virtualTime float64
+	queue       []*reqContainer
+}
+
+// push adds a request to the queue.
+func (s *scheduler) push(container *reqContainer) {
+	s.seq++
+	container.seq = s.seq
+	if c := container.client; c != nil {
+		// a request finishes 1/weight units of virtual time after the
+		// client's previous request, or after now if the client was idle
+		c.lastFinish = max(c.lastFinish, s.virtualTime) + 1/float64(max(c.class.Weight, 1))
+		container.finish = c.lastFinish
+	}
+	s.queue = append(s.queue, container)
+}
+
+// requeue puts a request taken off the queue back in its original position.
+func (s *scheduler) requeue(container *reqContainer) {
+	s.queue = append(s.queue, container)
+}
+
+// pop removes and returns the request to send next. If holder is not nil,
+// only its requests are considered; pop returns nil if it has none.
+func (s *scheduler) pop(holder *clientConn) *reqContainer {
+	i := -1
+	for j, container := range s.queue {
+		if holder != nil && container.client != holder {
+			continue
+		}
+		if i < 0 || s.before(container, s.queue[i]) {
+			i = j
+		}
+	}
+	if i < 0 {
+		return nil
+	}
+	container := s.queue[i]
+	s.queue = slices.Delete(s.queue, i, i+1)
+	s.virtualTime = max(s.virtualTime, container.finish)
+	return container
+}
+
+func (s *scheduler) before(a, b *reqContainer) bool {
+	if s.wfq {
+		if a.finish != b.finish {
+			return a.finish < b.finish
+		}
+	} else if pa, pb := a.priority(), b.priority(); pa != pb {
+		return pa > pb
+	}
+	return a.seq < b.seq
+}
+
+func (s *scheduler) len() int {
+	return len(s.queue)
+}
+
+// has reports whether a request can be popped for holder.
+func (s *scheduler) has(holder *clientConn) bool {
+	if holder == nil {
+		return len(s.queue) > 0
+	}
+	return slices.ContainsFunc(s.queue, func(container *reqContainer) bool {
+		return container.client == holder
+	})
+}
+
+// filter keeps only the requests for which keep returns true.
+func (s *scheduler) filter(keep func(*reqContainer) bool) {
+	s.queue = slices.DeleteFunc(s.queue, func(container *reqContainer) bool {
+		return !keep(container)
+	})
+}
+
+func (container *reqContainer) priority() int {
+	if container.client == nil {
+		return 0
+	}
+	return container.client.class.Priority
+}
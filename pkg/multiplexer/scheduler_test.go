@@ -0,0 +1,142 @@
+package multiplexer
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestParseClass(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Class
+		wantErr bool
+	}{
+		{name: "name only", s: "name=dashboard", want: Class{Name: "dashboard", Weight: 1}},
+		{name: "priority and weight", s: "name=ems,priority=10,weight=4", want: Class{Name: "ems", Priority: 10, Weight: 4}},
+		{name: "ports", s: "name=ems,port=5021,port=5022", want: Class{Name: "ems", Weight: 1, Ports: []string{"5021", "5022"}}},
+		{name: "no name", s: "priority=10", wantErr: true},
+		{name: "unknown key", s: "name=ems,color=red", wantErr: true},
+		{name: "zero weight", s: "name=ems,weight=0", wantErr: true},
+		{name: "negative weight", s: "name=ems,weight=-1", wantErr: true},
+		{name: "invalid cidr", s: "name=ems,cidr=10.0.0.1", wantErr: true},
+		{name: "missing value", s: "name=ems,priority", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseClass(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseClass() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.want.Name || got.Priority != tt.want.Priority || got.Weight != tt.want.Weight || !slices.Equal(got.Ports, tt.want.Ports) {
+				t.Fatalf("ParseClass() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	ems, err := ParseClass("name=ems,cidr=10.0.0.0/24")
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	admin, err := ParseClass("name=admin,port=5021")
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	classes := []Class{ems, admin}
+
+	tests := []struct {
+		name   string
+		local  net.Addr
+		remote net.Addr
+		want   string
+	}{
+		{name: "source address", local: &net.TCPAddr{Port: 8000}, remote: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5)}, want: "ems"},
+		{name: "listen port", local: &net.TCPAddr{Port: 5021}, remote: &net.TCPAddr{IP: net.IPv4(192, 168, 1, 5)}, want: "admin"},
+		{name: "first match", local: &net.TCPAddr{Port: 5021}, remote: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5)}, want: "ems"},
+		{name: "no match", local: &net.TCPAddr{Port: 8000}, remote: &net.TCPAddr{IP: net.IPv4(192, 168, 1, 5)}, want: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(classes, tt.local, tt.remote); got.Name != tt.want {
+				t.Fatalf("classify() got = %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+// schedule pushes the requests and returns the ids of their clients in the
+// order the scheduler sends them.
+func schedule(s *scheduler, requests []*reqContainer) []int {
+	for _, r := range requests {
+		s.push(r)
+	}
+	var order []int
+	for s.len() > 0 {
+		order = append(order, s.pop(nil).client.id)
+	}
+	return order
+}
+
+func TestScheduler_StrictPriority(t *testing.T) {
+	dashboard := &clientConn{id: 1, class: &Class{Name: "dashboard", Weight: 1}}
+	ems := &clientConn{id: 2, class: &Class{Name: "ems", Priority: 10, Weight: 1}}
+
+	got := schedule(&scheduler{}, []*reqContainer{
+		{client: dashboard},
+		{client: dashboard},
+		{client: ems},
+		{client: dashboard},
+		{client: ems},
+	})
+	want := []int{2, 2, 1, 1, 1}
+	if !slices.Equal(got, want) {
+		t.Fatalf("schedule() got = %v, want %v", got, want)
+	}
+}
+
+func TestScheduler_WeightedFairQueuing(t *testing.T) {
+	dashboard := &clientConn{id: 1, class: &Class{Name: "dashboard", Weight: 1}}
+	ems := &clientConn{id: 2, class: &Class{Name: "ems", Weight: 4}}
+
+	var requests []*reqContainer
+	for range 4 {
+		requests = append(requests, &reqContainer{client: dashboard})
+	}
+	for range 6 {
+		requests = append(requests, &reqContainer{client: ems})
+	}
+
+	// the dashboard queued first, but ems gets four requests for each of
+	// the dashboard's while both have requests waiting
+	got := schedule(&scheduler{wfq: true}, requests)
+	want := []int{2, 2, 2, 1, 2, 2, 2, 1, 1, 1}
+	if !slices.Equal(got, want) {
+		t.Fatalf("schedule() got = %v, want %v", got, want)
+	}
+
+	// clients that were idle do not get credit for the time they were idle
+	requests = []*reqContainer{{client: dashboard}, {client: ems}, {client: ems}, {client: ems}}
+	got = schedule(&scheduler{wfq: true, virtualTime: 10}, requests)
+	want = []int{2, 2, 2, 1}
+	if !slices.Equal(got, want) {
+		t.Fatalf("schedule() got = %v, want %v", got, want)
+	}
+
+	// a class without a weight is scheduled like weight 1 instead of never
+	unweighted := &clientConn{id: 3, class: &Class{Name: "unweighted"}}
+	weighted := &clientConn{id: 1, class: &Class{Name: "dashboard", Weight: 1}}
+	requests = []*reqContainer{{client: unweighted}, {client: unweighted}, {client: weighted}, {client: weighted}}
+	got = schedule(&scheduler{wfq: true}, requests)
+	want = []int{3, 1, 3, 1}
+	if !slices.Equal(got, want) {
+		t.Fatalf("schedule() got = %v, want %v", got, want)
+	}
+}
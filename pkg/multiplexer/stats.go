@@ -0,0 +1,66 @@
+package multiplexer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the state of a Multiplexer, e.g. for exporting
+// metrics.
+type Stats struct {
+	// Circuit is the state of the circuit breaker guarding the target server.
+	Circuit CircuitState `json:"circuit"`
+	// Clients is the number of connected clients.
+	Clients int64 `json:"clients"`
+	// Forwarded is the number of requests forwarded to the target server.
+	Forwarded uint64 `json:"forwarded"`
+	// Failed is the number of requests that failed because the target server
+	// was unavailable or did not answer.
+	Failed uint64 `json:"failed"`
+	// Expired is the number of requests dropped from the queue because they
+	// exceeded the request timeout before they could be sent.
+	Expired uint64 `json:"expired"`
+	// Canceled is the number of requests skipped because their client
+	// disconnected before they could be sent.
+	Canceled uint64 `json:"canceled"`
+	// Limited is the number of requests that exceeded a rate limit.
+	Limited uint64 `json:"limited"`
+	// Rejected is the number of client connections rejected at accept time.
+	Rejected uint64 `json:"rejected"`
+}
+
+// stats holds the counters behind Stats. They are updated by the target loop
+// and may be read concurrently.
+type stats struct {
+	circuit   atomic.Int32
+	clients   atomic.Int64
+	forwarded atomic.Uint64
+	failed    atomic.Uint64
+	expired   atomic.Uint64
+	canceled  atomic.Uint64
+	limited   atomic.Uint64
+	rejected  atomic.Uint64
+}
+
+// Stats returns a snapshot of the multiplexer's state.
+func (mux *Multiplexer) Stats() Stats {
+	return Stats{
+		Circuit:   CircuitState(mux.stats.circuit.Load()),
+		Clients:   mux.stats.clients.Load(),
+		Forwarded: mux.stats.forwarded.Load(),
+		Failed:    mux.stats.failed.Load(),
+		Expired:   mux.stats.expired.Load(),
+		Canceled:  mux.stats.canceled.Load(),
+		Limited:   mux.stats.limited.Load(),
+		Rejected:  mux.stats.rejected.Load(),
+	}
+}
+
+// StatusHandler serves Stats as JSON, e.g. for monitoring the circuit state.
+func (mux *Multiplexer) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(mux.Stats())
+	})
+}
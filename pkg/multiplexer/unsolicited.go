@@ -0,0 +1,191 @@
+package multiplexer
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+)
+
+// UnsolicitedAction is what the multiplexer does with frames the target
+// server sends on its own rather than in response to a request.
+type UnsolicitedAction int
+
+const (
+	// UnsolicitedDisabled reads from the target server only in response to a
+	// request, so that unsolicited frames are taken for the next response.
+	UnsolicitedDisabled UnsolicitedAction = iota
+	// UnsolicitedDrop logs and drops unsolicited frames.
+	UnsolicitedDrop
+	// UnsolicitedBroadcast sends unsolicited frames to all clients.
+	UnsolicitedBroadcast
+	// UnsolicitedClient sends unsolicited frames to the longest connected
+	// client of a class.
+	UnsolicitedClient
+	// UnsolicitedAnswer lets the protocol answer unsolicited frames.
+	UnsolicitedAnswer
+)
+
+func (a UnsolicitedAction) String() string {
+	switch a {
+	case UnsolicitedDisabled:
+		return "disabled"
+	case UnsolicitedDrop:
+		return "drop"
+	case UnsolicitedBroadcast:
+		return "broadcast"
+	case UnsolicitedClient:
+		return "client"
+	case UnsolicitedAnswer:
+		return "answer"
+	}
+	return fmt.Sprintf("UnsolicitedAction(%d)", int(a))
+}
+
+// UnsolicitedRoute configures how unsolicited frames are handled.
+type UnsolicitedRoute struct {
+	Action UnsolicitedAction
+	// Class is the class of the client that receives unsolicited frames
+	// with UnsolicitedClient.
+	Class string
+}
+
+// ParseUnsolicitedRoute parses drop, broadcast, answer or class:NAME.
+func ParseUnsolicitedRoute(s string) (UnsolicitedRoute, error) {
+	switch s {
+	case "":
+		return UnsolicitedRoute{}, nil
+	case "drop":
+		return UnsolicitedRoute{Action: UnsolicitedDrop}, nil
+	case "broadcast":
+		return UnsolicitedRoute{Action: UnsolicitedBroadcast}, nil
+	case "answer":
+		return UnsolicitedRoute{Action: UnsolicitedAnswer}, nil
+	}
+	if class, ok := strings.CutPrefix(s, "class:"); ok && class != "" {
+		return UnsolicitedRoute{Action: UnsolicitedClient, Class: class}, nil
+	}
+	return UnsolicitedRoute{}, fmt.Errorf("unknown route for unsolicited frames %q", s)
+}
+
+// targetFrame is a frame read from the target server in the background.
+type targetFrame struct {
+	msg []byte
+	err error
+}
+
+// backgroundReader reads frames from a target connection as they arrive, so
+// that frames which are no response to a request are noticed.
+type backgroundReader struct {
+	frames chan targetFrame
+	done   chan struct{}
+}
+
+func (mux *Multiplexer) startReader(conn targetConn) *backgroundReader {
+	r := &backgroundReader{frames: make(chan targetFrame), done: make(chan struct{})}
+	go func() {
+		for {
+			// silence framing leaves a deadline behind
+			err := conn.SetReadDeadline(time.Time{})
+			if err != nil {
+				slog.Error("error setting read deadline", "error", err)
+			}
+			msg, err := message.ReadResponse(mux.targetReader, conn)
+			select {
+			case r.frames <- targetFrame{msg: msg, err: err}:
+			case <-r.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// stop makes the reader exit once its connection has been closed.
+func (r *backgroundReader) stop() {
+	close(r.done)
+}
+
+// read waits for the response to req for up to timeout, passing unsolicited
+// frames that arrive in the meantime to route.
+func (r *backgroundReader) read(req []byte, timeout time.Duration, correlator message.Correlator, route func([]byte)) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case f := <-r.frames:
+			if f.err != nil {
+				return nil, f.err
+			}
+			if correlator != nil && !correlator.Matches(req, f.msg) {
+				route(f.msg)
+				continue
+			}
+			return f.msg, nil
+		case <-timer.C:
+			return nil, fmt.Errorf("no response from target within %v", timeout)
+		}
+	}
+}
+
+// route handles a frame the target server sent on its own.
+func (mux *Multiplexer) route(msg []byte, clients []*clientConn, conn targetConn) {
+	slog.Info("unsolicited frame from target server", "frame", mux.logTarget(msg, false))
+
+	var recipients []*clientConn
+	switch mux.unsolicited.Action {
+	case UnsolicitedBroadcast:
+		recipients = clients
+	case UnsolicitedClient:
+		for _, c := range clients {
+			if c.class.Name == mux.unsolicited.Class {
+				recipients = []*clientConn{c}
+				break
+			}
+		}
+	case UnsolicitedAnswer:
+		a, ok := mux.targetReader.(message.Answerer)
+		if !ok {
+			slog.Warn("dropping unsolicited frame, protocol cannot answer it", "protocol", mux.targetReader.Name())
+			return
+		}
+		reply, err := a.Answer(msg)
+		if err != nil {
+			slog.Warn("dropping unsolicited frame", "error", err)
+			return
+		}
+		if reply == nil {
+			slog.Debug("unsolicited frame needs no answer")
+			return
+		}
+		err = conn.SetWriteDeadline(time.Now().Add(mux.responseTimeout))
+		if err != nil {
+			slog.Error("error setting write deadline", "error", err)
+		}
+		if _, err := conn.Write(reply); err != nil {
+			slog.Error("error answering unsolicited frame", "error", err)
+		}
+		return
+	}
+
+	if mux.converter != nil {
+		// frames can only be converted together with the request they answer
+		recipients = nil
+	}
+	if len(recipients) == 0 {
+		slog.Warn("dropping unsolicited frame", "route", mux.unsolicited.Action)
+		return
+	}
+	for _, c := range recipients {
+		select {
+		case c.push <- msg:
+		default:
+			slog.Warn("dropping unsolicited frame for busy client", "id", c.id)
+		}
+	}
+}
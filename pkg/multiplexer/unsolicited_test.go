@@ -0,0 +1,31 @@
+package multiplexer
+
+import "testing"
+
+func TestParseUnsolicitedRoute(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    UnsolicitedRoute
+		wantErr bool
+	}{
+		{in: "", want: UnsolicitedRoute{}},
+		{in: "drop", want: UnsolicitedRoute{Action: UnsolicitedDrop}},
+		{in: "broadcast", want: UnsolicitedRoute{Action: UnsolicitedBroadcast}},
+		{in: "answer", want: UnsolicitedRoute{Action: UnsolicitedAnswer}},
+		{in: "class:ems", want: UnsolicitedRoute{Action: UnsolicitedClient, Class: "ems"}},
+		{in: "class:", wantErr: true},
+		{in: "all", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseUnsolicitedRoute(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseUnsolicitedRoute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseUnsolicitedRoute() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
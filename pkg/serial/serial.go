@@ -0,0 +1,89 @@
+// Package serial opens local serial devices configured for raw binary
+// transfer, e.g. to talk Modbus RTU to devices on an RS-485 bus.
+package serial
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Parity is the parity mode of a serial line.
+type Parity byte
+
+const (
+	ParityNone Parity = 'N'
+	ParityEven Parity = 'E'
+	ParityOdd  Parity = 'O'
+)
+
+// Config holds the line settings of a serial device.
+type Config struct {
+	BaudRate int
+	DataBits int
+	Parity   Parity
+	StopBits int
+}
+
+// DefaultConfig is the Modbus RTU default of 19200 baud, 8 data bits, even
+// parity and one stop bit.
+var DefaultConfig = Config{
+	BaudRate: 19200,
+	DataBits: 8,
+	Parity:   ParityEven,
+	StopBits: 1,
+}
+
+// ParseParity parses a parity given as N, E or O (or none, even, odd).
+func ParseParity(s string) (Parity, error) {
+	switch strings.ToUpper(s) {
+	case "N", "NONE":
+		return ParityNone, nil
+	case "E", "EVEN":
+		return ParityEven, nil
+	case "O", "ODD":
+		return ParityOdd, nil
+	}
+	return 0, fmt.Errorf("invalid parity %q", s)
+}
+
+// IsDevice reports whether target names a local device rather than a network
+// address.
+func IsDevice(target string) bool {
+	return strings.HasPrefix(target, "/")
+}
+
+// Open opens the serial device at path and configures it according to cfg.
+// The returned file supports read and write deadlines.
+func Open(path string, cfg Config) (*os.File, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|openFlags, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := configure(f, cfg); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to configure %s: %w", path, err)
+	}
+
+	return f, nil
+}
+
+func (c Config) validate() error {
+	if c.DataBits < 5 || c.DataBits > 8 {
+		return fmt.Errorf("invalid number of data bits %d", c.DataBits)
+	}
+	if c.StopBits != 1 && c.StopBits != 2 {
+		return fmt.Errorf("invalid number of stop bits %d", c.StopBits)
+	}
+	switch c.Parity {
+	case ParityNone, ParityEven, ParityOdd:
+	default:
+		return fmt.Errorf("invalid parity %q", c.Parity)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package serial
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	openFlags = syscall.O_NOCTTY
+	// cbaud masks the baud rate bits in c_cflag; it is missing from package syscall.
+	cbaud = 0x100f
+)
+
+var baudRates = map[int]uint32{
+	1200:    syscall.B1200,
+	2400:    syscall.B2400,
+	4800:    syscall.B4800,
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	500000:  syscall.B500000,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+}
+
+var dataBits = map[int]uint32{
+	5: syscall.CS5,
+	6: syscall.CS6,
+	7: syscall.CS7,
+	8: syscall.CS8,
+}
+
+func configure(f *os.File, cfg Config) error {
+	speed, ok := baudRates[cfg.BaudRate]
+	if !ok {
+		return fmt.Errorf("unsupported baud rate %d", cfg.BaudRate)
+	}
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		var t syscall.Termios
+		if _, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+			return
+		}
+
+		// raw mode, see cfmakeraw(3)
+		t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF | syscall.INPCK
+		t.Oflag &^= syscall.OPOST
+		t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		t.Cflag &^= cbaud | syscall.CSIZE | syscall.CSTOPB | syscall.PARENB | syscall.PARODD
+		t.Cflag |= syscall.CREAD | syscall.CLOCAL | speed | dataBits[cfg.DataBits]
+		t.Ispeed = speed
+		t.Ospeed = speed
+
+		switch cfg.Parity {
+		case ParityEven:
+			t.Cflag |= syscall.PARENB
+			t.Iflag |= syscall.INPCK
+		case ParityOdd:
+			t.Cflag |= syscall.PARENB | syscall.PARODD
+			t.Iflag |= syscall.INPCK
+		case ParityNone:
+		}
+		if cfg.StopBits == 2 {
+			t.Cflag |= syscall.CSTOPB
+		}
+
+		// return from read as soon as a single byte is available
+		t.Cc[syscall.VMIN] = 1
+		t.Cc[syscall.VTIME] = 0
+
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
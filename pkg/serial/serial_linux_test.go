@@ -0,0 +1,107 @@
+//go:build linux
+
+package serial
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPTY opens a pseudo-terminal pair and returns the master and the path of
+// the slave device.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("pseudo-terminals are not available:", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+
+	rc, err := master.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n uint32
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		var unlock int32
+		if _, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+			return
+		}
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestOpen(t *testing.T) {
+	master, slave := openPTY(t)
+
+	port, err := Open(slave, Config{BaudRate: 9600, DataBits: 8, Parity: ParityNone, StopBits: 1})
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	defer func() { _ = port.Close() }()
+
+	// binary data must pass unmodified in both directions
+	frame := []byte{0x01, 0x03, 0x00, 0x0a, 0x0d, 0x11, 0x13, 0x84, 0x0A}
+	if _, err := master.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	if err := port.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal("Expected read deadline support, but got:", err)
+	}
+	got := make([]byte, len(frame))
+	if _, err := io.ReadFull(port, got); err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	if !bytes.Equal(got, frame) {
+		t.Fatalf("got %x, want %x", got, frame)
+	}
+
+	if _, err := port.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(master, got); err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	if !bytes.Equal(got, frame) {
+		t.Fatalf("got %x, want %x", got, frame)
+	}
+
+	// a read without data must time out
+	if err := port.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := port.Read(got); !os.IsTimeout(err) {
+		t.Fatal("Expected timeout, but got:", err)
+	}
+}
+
+func TestOpen_InvalidConfig(t *testing.T) {
+	_, slave := openPTY(t)
+
+	for _, cfg := range []Config{
+		{BaudRate: 12345, DataBits: 8, Parity: ParityNone, StopBits: 1},
+		{BaudRate: 9600, DataBits: 9, Parity: ParityNone, StopBits: 1},
+		{BaudRate: 9600, DataBits: 8, Parity: 'X', StopBits: 1},
+		{BaudRate: 9600, DataBits: 8, Parity: ParityNone, StopBits: 3},
+	} {
+		if port, err := Open(slave, cfg); err == nil {
+			_ = port.Close()
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
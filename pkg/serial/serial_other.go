@@ -0,0 +1,14 @@
+//go:build !linux
+
+package serial
+
+import (
+	"errors"
+	"os"
+)
+
+const openFlags = 0
+
+func configure(_ *os.File, _ Config) error {
+	return errors.New("serial devices are only supported on Linux")
+}
@@ -0,0 +1,233 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"sync"
+)
+
+const (
+	funcReadCoils              = 1
+	funcReadDiscreteInputs     = 2
+	funcReadHoldingRegisters   = 3
+	funcReadInputRegisters     = 4
+	funcWriteSingleCoil        = 5
+	funcWriteSingleRegister    = 6
+	funcWriteMultipleCoils     = 15
+	funcWriteMultipleRegisters = 16
+	funcMaskWriteRegister      = 22
+	funcReadWriteRegisters     = 23
+
+	exceptionBit = 0x80
+
+	exceptionIllegalFunction    = 0x01
+	exceptionIllegalDataAddress = 0x02
+	exceptionIllegalDataValue   = 0x03
+	exceptionServerDeviceBusy   = 0x06
+
+	// Requests to this unit ID are executed by all units and not answered.
+	broadcastUnitID = 0
+)
+
+// Device executes Modbus requests on the units of a simulated device or of
+// the devices on a simulated serial line.
+type Device struct {
+	Units map[byte]*Unit
+	// ExceptionRate is the probability with which a request is answered
+	// with the exception code Exception (server device busy if 0) instead of
+	// being executed.
+	ExceptionRate float64
+	Exception     byte
+
+	mu sync.Mutex
+}
+
+// Handle executes the request pdu on unit and returns the response PDU, or
+// nil if the request is not answered: requests to units that do not exist
+// are ignored, as are broadcasts, which are executed by all units.
+func (d *Device) Handle(unit byte, pdu []byte) []byte {
+	if len(pdu) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if unit == broadcastUnitID {
+		for _, u := range d.Units {
+			_ = execute(u, pdu)
+		}
+		return nil
+	}
+	u, ok := d.Units[unit]
+	if !ok {
+		return nil
+	}
+	if d.ExceptionRate > 0 && rand.Float64() < d.ExceptionRate {
+		e := d.Exception
+		if e == 0 {
+			e = exceptionServerDeviceBusy
+		}
+		return []byte{pdu[0] | exceptionBit, e}
+	}
+	return execute(u, pdu)
+}
+
+// execute returns the response to pdu, an exception response if it fails.
+func execute(u *Unit, pdu []byte) []byte {
+	code, data := pdu[0], pdu[1:]
+	exception := func(e byte) []byte {
+		return []byte{code | exceptionBit, e}
+	}
+	word := func(i int) uint16 {
+		return binary.BigEndian.Uint16(data[i:])
+	}
+
+	switch code {
+	case funcReadCoils, funcReadDiscreteInputs:
+		if len(data) != 4 {
+			return exception(exceptionIllegalDataValue)
+		}
+		table := u.Coils
+		if code == funcReadDiscreteInputs {
+			table = u.DiscreteInputs
+		}
+		address, quantity := word(0), word(2)
+		if quantity < 1 || quantity > 2000 {
+			return exception(exceptionIllegalDataValue)
+		}
+		if !defined(table, address, quantity) {
+			return exception(exceptionIllegalDataAddress)
+		}
+		bits := make([]byte, (quantity+7)/8)
+		for i := range quantity {
+			if table[address+i] {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append([]byte{code, byte(len(bits))}, bits...)
+
+	case funcReadHoldingRegisters, funcReadInputRegisters:
+		if len(data) != 4 {
+			return exception(exceptionIllegalDataValue)
+		}
+		table := u.HoldingRegisters
+		if code == funcReadInputRegisters {
+			table = u.InputRegisters
+		}
+		address, quantity := word(0), word(2)
+		if quantity < 1 || quantity > 125 {
+			return exception(exceptionIllegalDataValue)
+		}
+		if !defined(table, address, quantity) {
+			return exception(exceptionIllegalDataAddress)
+		}
+		return readRegisters(code, table, address, quantity)
+
+	case funcWriteSingleCoil:
+		if len(data) != 4 || (word(2) != 0xFF00 && word(2) != 0) {
+			return exception(exceptionIllegalDataValue)
+		}
+		if !defined(u.Coils, word(0), 1) {
+			return exception(exceptionIllegalDataAddress)
+		}
+		u.Coils[word(0)] = word(2) == 0xFF00
+		return pdu
+
+	case funcWriteSingleRegister:
+		if len(data) != 4 {
+			return exception(exceptionIllegalDataValue)
+		}
+		if !defined(u.HoldingRegisters, word(0), 1) {
+			return exception(exceptionIllegalDataAddress)
+		}
+		u.HoldingRegisters[word(0)] = word(2)
+		return pdu
+
+	case funcWriteMultipleCoils:
+		if len(data) < 5 {
+			return exception(exceptionIllegalDataValue)
+		}
+		address, quantity := word(0), word(2)
+		if quantity < 1 || quantity > 1968 || int(data[4]) != int(quantity+7)/8 || len(data) != 5+int(data[4]) {
+			return exception(exceptionIllegalDataValue)
+		}
+		if !defined(u.Coils, address, quantity) {
+			return exception(exceptionIllegalDataAddress)
+		}
+		for i := range quantity {
+			u.Coils[address+i] = data[5+i/8]&(1<<(i%8)) != 0
+		}
+		return pdu[:5]
+
+	case funcWriteMultipleRegisters:
+		if len(data) < 5 {
+			return exception(exceptionIllegalDataValue)
+		}
+		address, quantity := word(0), word(2)
+		if quantity < 1 || quantity > 123 || int(data[4]) != 2*int(quantity) || len(data) != 5+int(data[4]) {
+			return exception(exceptionIllegalDataValue)
+		}
+		if !defined(u.HoldingRegisters, address, quantity) {
+			return exception(exceptionIllegalDataAddress)
+		}
+		for i := range quantity {
+			u.HoldingRegisters[address+i] = word(5 + 2*int(i))
+		}
+		return pdu[:5]
+
+	case funcMaskWriteRegister:
+		if len(data) != 6 {
+			return exception(exceptionIllegalDataValue)
+		}
+		if !defined(u.HoldingRegisters, word(0), 1) {
+			return exception(exceptionIllegalDataAddress)
+		}
+		and, or := word(2), word(4)
+		u.HoldingRegisters[word(0)] = u.HoldingRegisters[word(0)]&and | or&^and
+		return pdu
+
+	case funcReadWriteRegisters:
+		if len(data) < 9 {
+			return exception(exceptionIllegalDataValue)
+		}
+		readAddress, readQuantity := word(0), word(2)
+		writeAddress, writeQuantity := word(4), word(6)
+		if readQuantity < 1 || readQuantity > 125 || writeQuantity < 1 || writeQuantity > 121 ||
+			int(data[8]) != 2*int(writeQuantity) || len(data) != 9+int(data[8]) {
+			return exception(exceptionIllegalDataValue)
+		}
+		if !defined(u.HoldingRegisters, readAddress, readQuantity) || !defined(u.HoldingRegisters, writeAddress, writeQuantity) {
+			return exception(exceptionIllegalDataAddress)
+		}
+		// the write is performed before the read
+		for i := range writeQuantity {
+			u.HoldingRegisters[writeAddress+i] = word(9 + 2*int(i))
+		}
+		return readRegisters(code, u.HoldingRegisters, readAddress, readQuantity)
+	}
+	return exception(exceptionIllegalFunction)
+}
+
+// defined reports whether all quantity addresses starting at address exist in
+// table.
+func defined[V any](table map[uint16]V, address, quantity uint16) bool {
+	if int(address)+int(quantity) > 1<<16 {
+		return false
+	}
+	for i := range quantity {
+		if _, ok := table[address+i]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func readRegisters(code byte, table map[uint16]uint16, address, quantity uint16) []byte {
+	resp := make([]byte, 2, 2+2*int(quantity))
+	resp[0], resp[1] = code, byte(2*quantity)
+	for i := range quantity {
+		resp = binary.BigEndian.AppendUint16(resp, table[address+i])
+	}
+	return resp
+}
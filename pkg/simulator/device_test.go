@@ -0,0 +1,101 @@
+package simulator
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseRegisterMap(t *testing.T) {
+	units, err := ParseRegisterMap([]byte(`{
+		"1": {
+			"coils": {"0": [true, false, 1]},
+			"discreteInputs": {"4": true},
+			"holdingRegisters": {"0": [230, 231], "0x64": 5000},
+			"inputRegisters": {"10": 7}
+		},
+		"2": {}
+	}`))
+	if err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	if len(units) != 2 {
+		t.Fatalf("Expected 2 units, but got %d", len(units))
+	}
+	u := units[1]
+	if len(u.Coils) != 3 || !u.Coils[0] || u.Coils[1] || !u.Coils[2] {
+		t.Errorf("Unexpected coils %v", u.Coils)
+	}
+	if !u.DiscreteInputs[4] {
+		t.Errorf("Unexpected discrete inputs %v", u.DiscreteInputs)
+	}
+	if len(u.HoldingRegisters) != 3 || u.HoldingRegisters[1] != 231 || u.HoldingRegisters[100] != 5000 {
+		t.Errorf("Unexpected holding registers %v", u.HoldingRegisters)
+	}
+	if u.InputRegisters[10] != 7 {
+		t.Errorf("Unexpected input registers %v", u.InputRegisters)
+	}
+
+	for _, invalid := range []string{
+		`{"0": {}}`,
+		`{"256": {}}`,
+		`{"1": {"coils": {"0": 2}}}`,
+		`{"1": {"holdingRegisters": {"0": 65536}}}`,
+		`{"1": {"holdingRegisters": {"0": 1.5}}}`,
+		`{"1": {"holdingRegisters": {"x": 1}}}`,
+		`{"1": {"inputRegisters": {"65535": [1, 2]}}}`,
+		`{"1": {"inputRegisters": {"0": "1"}}}`,
+	} {
+		if _, err := ParseRegisterMap([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error parsing %s", invalid)
+		}
+	}
+}
+
+func TestDevice_Handle(t *testing.T) {
+	d := &Device{Units: map[byte]*Unit{1: NewUnit(10), 2: NewUnit(10)}}
+	d.Units[1].HoldingRegisters[0] = 0x1234
+	d.Units[1].InputRegisters[9] = 7
+
+	tests := []struct {
+		name string
+		unit byte
+		pdu  []byte
+		want []byte
+	}{
+		{"read holding registers", 1, []byte{0x03, 0x00, 0x00, 0x00, 0x02}, []byte{0x03, 0x04, 0x12, 0x34, 0x00, 0x00}},
+		{"read input registers", 1, []byte{0x04, 0x00, 0x09, 0x00, 0x01}, []byte{0x04, 0x02, 0x00, 0x07}},
+		{"read beyond the map", 1, []byte{0x03, 0x00, 0x09, 0x00, 0x02}, []byte{0x83, 0x02}},
+		{"read too many registers", 1, []byte{0x03, 0x00, 0x00, 0x00, 0x7E}, []byte{0x83, 0x03}},
+		{"write single coil", 1, []byte{0x05, 0x00, 0x02, 0xFF, 0x00}, []byte{0x05, 0x00, 0x02, 0xFF, 0x00}},
+		{"invalid coil value", 1, []byte{0x05, 0x00, 0x02, 0x12, 0x34}, []byte{0x85, 0x03}},
+		{"write multiple coils", 1, []byte{0x0F, 0x00, 0x04, 0x00, 0x03, 0x01, 0x05}, []byte{0x0F, 0x00, 0x04, 0x00, 0x03}},
+		{"read coils", 1, []byte{0x01, 0x00, 0x00, 0x00, 0x08}, []byte{0x01, 0x01, 0x54}},
+		{"write single register", 1, []byte{0x06, 0x00, 0x01, 0xAB, 0xCD}, []byte{0x06, 0x00, 0x01, 0xAB, 0xCD}},
+		{"write multiple registers", 1, []byte{0x10, 0x00, 0x02, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02}, []byte{0x10, 0x00, 0x02, 0x00, 0x02}},
+		{"read written registers", 1, []byte{0x03, 0x00, 0x00, 0x00, 0x04}, []byte{0x03, 0x08, 0x12, 0x34, 0xAB, 0xCD, 0x00, 0x01, 0x00, 0x02}},
+		{"mask write register", 1, []byte{0x16, 0x00, 0x00, 0x00, 0xF2, 0x00, 0x25}, []byte{0x16, 0x00, 0x00, 0x00, 0xF2, 0x00, 0x25}},
+		{"read/write registers", 1, []byte{0x17, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01, 0x00, 0x01, 0x02, 0x00, 0x09}, []byte{0x17, 0x04, 0x00, 0x35, 0x00, 0x09}},
+		{"illegal function", 1, []byte{0x2B, 0x0E, 0x01, 0x00}, []byte{0xAB, 0x01}},
+		{"unknown unit", 3, []byte{0x03, 0x00, 0x00, 0x00, 0x01}, nil},
+		{"broadcast", 0, []byte{0x06, 0x00, 0x05, 0x00, 0x2A}, nil},
+		{"broadcast written by all units", 2, []byte{0x03, 0x00, 0x05, 0x00, 0x01}, []byte{0x03, 0x02, 0x00, 0x2A}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.Handle(tt.unit, tt.pdu); !bytes.Equal(got, tt.want) {
+				t.Errorf("Handle() got = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDevice_HandleException(t *testing.T) {
+	d := &Device{Units: map[byte]*Unit{1: NewUnit(1)}, ExceptionRate: 1}
+	if got, want := d.Handle(1, []byte{0x03, 0x00, 0x00, 0x00, 0x01}), []byte{0x83, 0x06}; !bytes.Equal(got, want) {
+		t.Errorf("Handle() got = %x, want %x", got, want)
+	}
+	d.Exception = 0x04
+	if got, want := d.Handle(1, []byte{0x03, 0x00, 0x00, 0x00, 0x01}), []byte{0x83, 0x04}; !bytes.Equal(got, want) {
+		t.Errorf("Handle() got = %x, want %x", got, want)
+	}
+}
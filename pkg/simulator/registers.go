@@ -0,0 +1,135 @@
+// Package simulator simulates Modbus devices, so that the multiplexer and its
+// clients can be tested without real hardware.
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Unit holds the data tables of a simulated device, keyed by zero-based
+// address. Addresses missing from a table are illegal data addresses.
+type Unit struct {
+	Coils            map[uint16]bool
+	DiscreteInputs   map[uint16]bool
+	HoldingRegisters map[uint16]uint16
+	InputRegisters   map[uint16]uint16
+}
+
+// NewUnit returns a unit with n zeroed entries in each of its tables.
+func NewUnit(n int) *Unit {
+	u := &Unit{
+		Coils:            make(map[uint16]bool, n),
+		DiscreteInputs:   make(map[uint16]bool, n),
+		HoldingRegisters: make(map[uint16]uint16, n),
+		InputRegisters:   make(map[uint16]uint16, n),
+	}
+	for a := range min(n, math.MaxUint16+1) {
+		u.Coils[uint16(a)] = false
+		u.DiscreteInputs[uint16(a)] = false
+		u.HoldingRegisters[uint16(a)] = 0
+		u.InputRegisters[uint16(a)] = 0
+	}
+	return u
+}
+
+type unitJSON struct {
+	Coils            map[string]json.RawMessage `json:"coils"`
+	DiscreteInputs   map[string]json.RawMessage `json:"discreteInputs"`
+	HoldingRegisters map[string]json.RawMessage `json:"holdingRegisters"`
+	InputRegisters   map[string]json.RawMessage `json:"inputRegisters"`
+}
+
+// ParseRegisterMap parses a JSON register map of units by unit ID. The tables
+// of a unit map zero-based start addresses to a value or to an array of
+// values at consecutive addresses; coils and discrete inputs are true, false,
+// 1 or 0:
+//
+//	{
+//	  "1": {
+//	    "coils": {"0": [true, false, true]},
+//	    "holdingRegisters": {"0": [230, 231, 229], "100": 5000},
+//	    "inputRegisters": {"0x10": [1, 2, 3]}
+//	  }
+//	}
+func ParseRegisterMap(data []byte) (map[byte]*Unit, error) {
+	var raw map[string]unitJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	units := make(map[byte]*Unit, len(raw))
+	for id, tables := range raw {
+		unit, err := strconv.ParseUint(id, 0, 8)
+		if err != nil || unit == 0 {
+			return nil, fmt.Errorf("invalid unit ID %q", id)
+		}
+		u := &Unit{
+			Coils:            make(map[uint16]bool),
+			DiscreteInputs:   make(map[uint16]bool),
+			HoldingRegisters: make(map[uint16]uint16),
+			InputRegisters:   make(map[uint16]uint16),
+		}
+		for _, t := range []struct {
+			name   string
+			values map[string]json.RawMessage
+			max    float64
+			set    func(address, value uint16)
+		}{
+			{"coils", tables.Coils, 1, func(a, v uint16) { u.Coils[a] = v != 0 }},
+			{"discreteInputs", tables.DiscreteInputs, 1, func(a, v uint16) { u.DiscreteInputs[a] = v != 0 }},
+			{"holdingRegisters", tables.HoldingRegisters, math.MaxUint16, func(a, v uint16) { u.HoldingRegisters[a] = v }},
+			{"inputRegisters", tables.InputRegisters, math.MaxUint16, func(a, v uint16) { u.InputRegisters[a] = v }},
+		} {
+			for start, value := range t.values {
+				if err := parseTable(start, value, t.max, t.set); err != nil {
+					return nil, fmt.Errorf("unit %d %s: %w", unit, t.name, err)
+				}
+			}
+		}
+		units[byte(unit)] = u
+	}
+	return units, nil
+}
+
+// parseTable sets the value or the values at consecutive addresses given for
+// the start address.
+func parseTable(start string, value json.RawMessage, maxValue float64, set func(address, value uint16)) error {
+	address, err := strconv.ParseUint(start, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", start)
+	}
+
+	var v any
+	if err := json.Unmarshal(value, &v); err != nil {
+		return err
+	}
+	values, ok := v.([]any)
+	if !ok {
+		values = []any{v}
+	}
+	if address+uint64(len(values)) > math.MaxUint16+1 {
+		return fmt.Errorf("%d values at address %d exceed the address range", len(values), address)
+	}
+
+	for i, v := range values {
+		var n float64
+		switch v := v.(type) {
+		case bool:
+			if v {
+				n = 1
+			}
+		case float64:
+			n = v
+		default:
+			return fmt.Errorf("invalid value %v at address %d", v, address+uint64(i))
+		}
+		if n < 0 || n > maxValue || n != math.Trunc(n) {
+			return fmt.Errorf("invalid value %v at address %d", v, address+uint64(i))
+		}
+		set(uint16(address+uint64(i)), uint16(n))
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+)
+
+// Server serves a simulated device with the framing of a Modbus reader.
+type Server struct {
+	Device *Device
+	// Reader is one of the Modbus readers, e.g. message.ModbusMessageReader
+	// for Modbus TCP or message.ModbusSerialMessageReader for RTU frames.
+	Reader message.Reader
+	// Latency and a random delay of up to Jitter pass before a response is
+	// sent.
+	Latency time.Duration
+	Jitter  time.Duration
+	// MaxConnections is the number of clients served at the same time;
+	// further connections are closed right away. 0 means unlimited.
+	MaxConnections int
+
+	mu    sync.Mutex
+	l     net.Listener
+	conns map[io.Closer]struct{}
+	wg    sync.WaitGroup
+}
+
+// Serve accepts connections on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	if !message.IsModbus(s.Reader) {
+		return errors.New("the simulator only serves Modbus")
+	}
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if !s.track(conn) {
+			slog.Warn("rejecting connection, too many clients", "remote", conn.RemoteAddr())
+			_ = conn.Close()
+			continue
+		}
+		slog.Info("new connection", "remote", conn.RemoteAddr())
+		s.wg.Go(func() {
+			defer s.untrack(conn)
+			s.ServeConn(conn)
+			slog.Info("connection closed", "remote", conn.RemoteAddr())
+		})
+	}
+}
+
+// ServeConn answers the requests read from conn until it is closed, e.g. a
+// serial device. Frames with protocol errors, e.g. a CRC mismatch, are
+// skipped, but an invalid MBAP header ends the connection.
+func (s *Server) ServeConn(conn io.ReadWriter) {
+	for {
+		req, err := message.ReadRequest(s.Reader, conn)
+		if err != nil && isProtocolError(err) {
+			// like a device on a serial line, wait for the next frame
+			slog.Warn("ignoring invalid frame", "error", err)
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Error("error reading request", "error", err)
+			}
+			return
+		}
+		m, err := message.DecodeModbus(s.Reader, req)
+		if err != nil {
+			// like a device on a serial line, ignore frames it cannot decode
+			slog.Warn("ignoring invalid request", "error", err, "hex", fmt.Sprintf("%x", req))
+			continue
+		}
+		slog.Debug("request", "unit", m.Unit, "pdu", fmt.Sprintf("%x", m.PDU))
+
+		pdu := s.Device.Handle(m.Unit, m.PDU)
+		if pdu == nil {
+			continue
+		}
+		s.delay()
+		m.PDU = pdu
+		resp, err := message.EncodeModbus(s.Reader, m)
+		if err != nil {
+			slog.Error("error encoding response", "error", err)
+			return
+		}
+		if _, err := conn.Write(resp); err != nil {
+			slog.Error("error writing response", "error", err)
+			return
+		}
+	}
+}
+
+// isProtocolError reports whether err is about a malformed frame rather than
+// the connection, so that the frames following it can still be read. After
+// an invalid MBAP header, the stream cannot be resynchronized.
+func isProtocolError(err error) bool {
+	return errors.Is(err, message.ErrProtocol) && !errors.Is(err, message.ErrMBAPHeader) &&
+		!errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Close stops accepting connections, closes the connected ones and waits
+// until they have been served.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	var err error
+	if s.l != nil {
+		err = s.l.Close()
+	}
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) delay() {
+	d := s.Latency
+	if s.Jitter > 0 {
+		d += rand.N(s.Jitter)
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// track registers conn unless MaxConnections are connected already.
+func (s *Server) track(conn io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MaxConnections > 0 && len(s.conns) >= s.MaxConnections {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[io.Closer]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn io.Closer) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	_ = conn.Close()
+}
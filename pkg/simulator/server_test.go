@@ -0,0 +1,150 @@
+package simulator
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ingmarstein/tcp-multiplexer/pkg/message"
+)
+
+func startServer(t *testing.T, s *Server) net.Addr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { _ = s.Close() })
+	return l.Addr()
+}
+
+func TestServer_Serve(t *testing.T) {
+	tests := []struct {
+		reader message.Reader
+		req    []byte
+		want   []byte
+	}{
+		{
+			&message.ModbusMessageReader{},
+			[]byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01},
+			[]byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2A},
+		},
+		{
+			&message.ModbusSerialMessageReader{},
+			[]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A},
+			[]byte{0x01, 0x03, 0x02, 0x00, 0x2A, 0x39, 0x9B},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reader.Name(), func(t *testing.T) {
+			u := NewUnit(1)
+			u.HoldingRegisters[0] = 42
+			addr := startServer(t, &Server{Device: &Device{Units: map[byte]*Unit{1: u}}, Reader: tt.reader, Latency: 10 * time.Millisecond})
+
+			conn, err := net.Dial("tcp", addr.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = conn.Close() }()
+
+			start := time.Now()
+			if _, err := conn.Write(tt.req); err != nil {
+				t.Fatal(err)
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			got := make([]byte, len(tt.want))
+			if _, err := io.ReadFull(conn, got); err != nil {
+				t.Fatal("Expected no error, but got:", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got = %x, want %x", got, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+				t.Errorf("Expected a latency of at least 10ms, but got %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestServer_ServeConnSkipsInvalidFrames(t *testing.T) {
+	u := NewUnit(1)
+	u.HoldingRegisters[0] = 42
+	addr := startServer(t, &Server{Device: &Device{Units: map[byte]*Unit{1: u}}, Reader: &message.ModbusSerialMessageReader{}})
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// a frame with a CRC mismatch is ignored, the next one is answered
+	if _, err := conn.Write([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0B}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	want := []byte{0x01, 0x03, 0x02, 0x00, 0x2A, 0x39, 0x9B}
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got = %x, want %x", got, want)
+	}
+}
+
+func TestServer_ServeConnClosesAfterInvalidMBAPHeader(t *testing.T) {
+	addr := startServer(t, &Server{Device: &Device{Units: map[byte]*Unit{1: NewUnit(1)}}, Reader: &message.ModbusMessageReader{}})
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// after an MBAP length of 0, the start of the next frame is unknown
+	if _, err := conn.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := conn.Read(make([]byte, 16)); err == nil {
+		t.Fatalf("Expected the connection to be closed, but got %d bytes", n)
+	}
+}
+
+func TestServer_MaxConnections(t *testing.T) {
+	addr := startServer(t, &Server{Device: &Device{Units: map[byte]*Unit{1: NewUnit(1)}}, Reader: &message.ModbusMessageReader{}, MaxConnections: 1})
+
+	first, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = first.Close() }()
+	// make sure the first connection has been accepted
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	if _, err := first.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	_ = first.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(first, make([]byte, 11)); err != nil {
+		t.Fatal("Expected no error, but got:", err)
+	}
+
+	second, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = second.Close() }()
+	_ = second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the second connection to be closed, but got: %v", err)
+	}
+}